@@ -0,0 +1,36 @@
+// cmd/admin.go
+package main
+
+import (
+	"fmt"
+
+	"Aervyn/internal/models"
+)
+
+// runAdminCommand handles maintenance commands given on the command line,
+// e.g. `aervyn refresh-actor https://example.com/users/alice`.
+func runAdminCommand(args []string) error {
+	switch args[0] {
+	case "refresh-actor":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: refresh-actor <actor-uri>")
+		}
+		return models.RefreshRemoteActor(args[1])
+	case "rebuild-timelines":
+		if len(args) > 2 {
+			return fmt.Errorf("usage: rebuild-timelines [username]")
+		}
+		username := ""
+		if len(args) == 2 {
+			username = args[1]
+		}
+		return models.RebuildHomeTimelines(username)
+	case "grant-admin":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: grant-admin <username>")
+		}
+		return models.SetAdmin(args[1], true)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
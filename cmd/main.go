@@ -4,6 +4,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
@@ -20,6 +21,19 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Run an admin command instead of serving if one was given
+	if len(os.Args) > 1 {
+		if err := runAdminCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Start outgoing activity delivery, inbox processing and outbox polling
+	models.StartDeliveryWorker()
+	models.StartInboxWorker()
+	models.StartOutboxPoller()
+
 	r := chi.NewRouter()
 
 	// Middleware
@@ -38,9 +52,19 @@ func main() {
 		r.Get("/register", handlers.RegisterHandler)
 		r.Post("/register", handlers.RegisterHandler)
 		r.Get("/.well-known/webfinger", handlers.WebFingerHandler)
+		r.Get("/.well-known/nodeinfo", handlers.NodeInfoLinksHandler)
+		r.Get("/.well-known/host-meta", handlers.HostMetaHandler)
+		r.Get("/.well-known/host-meta.json", handlers.HostMetaHandler)
+		r.Get("/nodeinfo/{version}", handlers.NodeInfoHandler)
 		r.Get("/users/{username}", handlers.ActorHandler)
 		r.Get("/users/{username}/outbox", handlers.OutboxHandler)
+		r.Get("/users/{username}/followers", handlers.FollowersHandler)
+		r.Get("/users/{username}/following", handlers.FollowingHandler)
 		r.Post("/users/{username}/inbox", handlers.InboxHandler)
+		r.Post("/inbox", handlers.SharedInboxHandler)
+		r.Get("/actor", handlers.InstanceActorHandler)
+		r.Get("/posts/{postID}", handlers.NoteHandler)
+		r.Get("/activities/{activityID}", handlers.ActivityHandler)
 	})
 
 	// Protected routes
@@ -61,10 +85,32 @@ func main() {
 		r.Put("/profile", handlers.ProfileUpdateHandler)
 		r.Post("/follow/{username}", handlers.FollowHandler)
 		r.Delete("/follow/{username}", handlers.UnfollowHandler)
+		r.Get("/follow-requests", handlers.FollowRequestsHandler)
+		r.Post("/follow-requests/{followID}/accept", handlers.AcceptFollowRequestHandler)
+		r.Post("/follow-requests/{followID}/reject", handlers.RejectFollowRequestHandler)
+		r.Post("/block/{username}", handlers.BlockHandler)
+		r.Delete("/block/{username}", handlers.UnblockHandler)
+		r.Post("/mute/{username}", handlers.MuteHandler)
+		r.Delete("/mute/{username}", handlers.UnmuteHandler)
+		r.Get("/report", handlers.ReportFormHandler)
+		r.Post("/report", handlers.CreateReportHandler)
 		r.Get("/timeline/following", handlers.FollowingTimelineHandler)
 		r.Get("/timeline/local", handlers.LocalTimelineHandler)
 	})
 
+	// Admin routes
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireAuth)
+		r.Use(middleware.RequireAdmin)
+		r.Get("/admin/domain-blocks", handlers.DomainBlocksHandler)
+		r.Post("/admin/domain-blocks", handlers.CreateDomainBlockHandler)
+		r.Delete("/admin/domain-blocks/{domain}", handlers.DeleteDomainBlockHandler)
+		r.Get("/admin/domain-blocks/export", handlers.ExportDomainBlocksHandler)
+		r.Post("/admin/domain-blocks/import", handlers.ImportDomainBlocksHandler)
+		r.Get("/admin/reports", handlers.ReportsHandler)
+		r.Post("/admin/reports/{reportID}", handlers.ResolveReportHandler)
+	})
+
 	log.Println("Server starting on http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
@@ -0,0 +1,106 @@
+package activitypub
+
+import (
+	"Aervyn/internal/config"
+	"encoding/xml"
+)
+
+const (
+	NodeInfo20Schema = "http://nodeinfo.diaspora.software/ns/schema/2.0"
+	NodeInfo21Schema = "http://nodeinfo.diaspora.software/ns/schema/2.1"
+)
+
+// NodeInfoLinks is the discovery document served at /.well-known/nodeinfo.
+type NodeInfoLinks struct {
+	Links []WebFingerLink `json:"links"`
+}
+
+type NodeInfo struct {
+	Version           string                 `json:"version"`
+	Software          NodeInfoSoftware       `json:"software"`
+	Protocols         []string               `json:"protocols"`
+	Services          NodeInfoServices       `json:"services"`
+	OpenRegistrations bool                   `json:"openRegistrations"`
+	Usage             NodeInfoUsage          `json:"usage"`
+	Metadata          map[string]interface{} `json:"metadata"`
+}
+
+type NodeInfoSoftware struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository,omitempty"`
+	Homepage   string `json:"homepage,omitempty"`
+}
+
+type NodeInfoServices struct {
+	Inbound  []string `json:"inbound"`
+	Outbound []string `json:"outbound"`
+}
+
+type NodeInfoUsage struct {
+	Users      NodeInfoUsers `json:"users"`
+	LocalPosts int           `json:"localPosts"`
+}
+
+type NodeInfoUsers struct {
+	Total          int `json:"total"`
+	ActiveMonth    int `json:"activeMonth"`
+	ActiveHalfyear int `json:"activeHalfyear"`
+}
+
+func NewNodeInfoLinks() NodeInfoLinks {
+	return NodeInfoLinks{
+		Links: []WebFingerLink{
+			{Rel: NodeInfo20Schema, Href: config.InstanceURL + "/nodeinfo/2.0"},
+			{Rel: NodeInfo21Schema, Href: config.InstanceURL + "/nodeinfo/2.1"},
+		},
+	}
+}
+
+// NewNodeInfo builds a NodeInfo document for version "2.0" or "2.1". The
+// software homepage is only part of the 2.1 schema.
+func NewNodeInfo(version string, usage NodeInfoUsage) NodeInfo {
+	info := NodeInfo{
+		Version: version,
+		Software: NodeInfoSoftware{
+			Name:    config.SoftwareName,
+			Version: config.SoftwareVersion,
+		},
+		Protocols:         []string{"activitypub"},
+		Services:          NodeInfoServices{Inbound: []string{}, Outbound: []string{}},
+		OpenRegistrations: config.OpenRegistrations,
+		Usage:             usage,
+		Metadata: map[string]interface{}{
+			"nodeName":        config.NodeName,
+			"nodeDescription": config.NodeDescription,
+		},
+	}
+	if version == "2.1" {
+		info.Software.Homepage = config.InstanceURL
+	}
+	return info
+}
+
+// HostMeta is the XRD document served at /.well-known/host-meta.
+type HostMeta struct {
+	XMLName xml.Name       `xml:"http://docs.oasis-open.org/ns/xri/xrd-1.0 XRD" json:"-"`
+	Links   []HostMetaLink `xml:"Link" json:"links"`
+}
+
+type HostMetaLink struct {
+	Rel      string `xml:"rel,attr" json:"rel"`
+	Type     string `xml:"type,attr,omitempty" json:"type,omitempty"`
+	Template string `xml:"template,attr" json:"template"`
+}
+
+func NewHostMeta() HostMeta {
+	return HostMeta{
+		Links: []HostMetaLink{
+			{
+				Rel:      "lrdd",
+				Type:     "application/jrd+json",
+				Template: config.InstanceURL + "/.well-known/webfinger?resource={uri}",
+			},
+		},
+	}
+}
@@ -2,24 +2,212 @@ package activitypub
 
 import (
 	"Aervyn/internal/config"
+	"bytes"
+	"crypto/rsa"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+var deliveryClient = &http.Client{Timeout: 30 * time.Second}
+
+// DeliveryError is returned when a remote inbox answers with a non-2xx status.
+type DeliveryError struct {
+	Inbox      string
+	StatusCode int
+	Body       string
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("delivery to %s failed (status %d): %s", e.Inbox, e.StatusCode, e.Body)
+}
+
+// Permanent reports whether retrying the delivery is pointless, e.g. the
+// inbox is gone or the remote server rejected the activity outright.
+func (e *DeliveryError) Permanent() bool {
+	switch {
+	case e.StatusCode == http.StatusRequestTimeout, e.StatusCode == http.StatusTooManyRequests:
+		return false
+	case e.StatusCode >= 400 && e.StatusCode < 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// NoteID returns the ActivityPub ID of a local post.
+func NoteID(postID string) string {
+	return fmt.Sprintf("%s/posts/%s", config.InstanceURL, postID)
+}
+
+// NewCreateActivity wraps a local Note in its Create activity. The activity
+// ID is derived from the post ID so it stays stable across deliveries.
+func NewCreateActivity(postID string, note Note) Activity {
+	return Activity{
+		Context:   "https://www.w3.org/ns/activitystreams",
+		ID:        fmt.Sprintf("%s/activities/%s", config.InstanceURL, postID),
+		Type:      "Create",
+		Actor:     note.AttributedTo,
+		Object:    note,
+		Published: note.Published,
+		To:        note.To,
+		Cc:        note.Cc,
+	}
+}
+
+// NewActivity builds an activity with a fresh ID under /activities.
+func NewActivity(activityType string, actor string, object interface{}) Activity {
+	return createActivity(activityType, actor, object)
+}
+
+// signatureRejected reports whether the peer likely refused our signature
+// rather than the activity itself.
+func (e *DeliveryError) signatureRejected() bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	default:
+		return false
+	}
+}
+
+// SignatureScheme identifies how outgoing requests are signed.
+type SignatureScheme string
+
+const (
+	SchemeCavage  SignatureScheme = "draft-cavage"
+	SchemeRFC9421 SignatureScheme = "rfc9421"
+)
+
+func (s SignatureScheme) other() SignatureScheme {
+	if s == SchemeRFC9421 {
+		return SchemeCavage
+	}
+	return SchemeRFC9421
+}
+
+// SchemeStore remembers which signature scheme each peer host accepts.
+type SchemeStore interface {
+	Get(host string) (SignatureScheme, bool)
+	Set(host string, scheme SignatureScheme)
+}
+
+// PeerSchemes is in memory by default; the models package replaces it with
+// a database backed store so the knowledge survives restarts.
+var PeerSchemes SchemeStore = &memorySchemeStore{schemes: make(map[string]SignatureScheme)}
+
+type memorySchemeStore struct {
+	mu      sync.Mutex
+	schemes map[string]SignatureScheme
+}
+
+func (m *memorySchemeStore) Get(host string) (SignatureScheme, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	scheme, ok := m.schemes[host]
+	return scheme, ok
+}
+
+func (m *memorySchemeStore) Set(host string, scheme SignatureScheme) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.schemes[host] = scheme
+}
+
 func createActivity(activityType string, actor string, object interface{}) Activity {
 	return Activity{
-		Context: "https://www.w3.org/ns/activitystreams",
-		Type:    activityType,
-		Actor:   actor,
-		Object:  object,
-		ID:      fmt.Sprintf("%s/activities/%s", config.InstanceURL, uuid.New().String()),
+		Context:   "https://www.w3.org/ns/activitystreams",
+		Type:      activityType,
+		Actor:     actor,
+		Object:    object,
+		ID:        fmt.Sprintf("%s/activities/%s", config.InstanceURL, uuid.New().String()),
+		Published: time.Now(),
+	}
+}
+
+// Deliver POSTs an already serialized activity to a remote inbox, signed with
+// the sending actor's PEM encoded private key.
+func Deliver(body []byte, inbox, keyID, privateKeyPem string) error {
+	privateKey, err := ParsePrivateKey(privateKeyPem)
+	if err != nil {
+		return fmt.Errorf("invalid private key for %s: %w", keyID, err)
+	}
+
+	return sendActivity(body, inbox, keyID, privateKey)
+}
+
+// sendActivity delivers with the signature scheme the peer is known to
+// accept. When the peer rejects the signature, the other scheme is tried and
+// remembered if it works.
+func sendActivity(body []byte, inbox, keyID string, privateKey *rsa.PrivateKey) error {
+	inboxURL, err := url.Parse(inbox)
+	if err != nil {
+		return err
+	}
+	host := inboxURL.Host
+
+	scheme, known := PeerSchemes.Get(host)
+	if !known {
+		scheme = SchemeCavage
+	}
+
+	err = postSigned(body, inbox, keyID, privateKey, scheme)
+	var deliveryErr *DeliveryError
+	if errors.As(err, &deliveryErr) && deliveryErr.signatureRejected() {
+		other := scheme.other()
+		if retryErr := postSigned(body, inbox, keyID, privateKey, other); retryErr == nil {
+			PeerSchemes.Set(host, other)
+			return nil
+		}
+		return err
 	}
+
+	if err == nil && !known {
+		PeerSchemes.Set(host, scheme)
+	}
+	return err
 }
 
-func sendActivity(activity Activity, inbox string) error {
+func postSigned(body []byte, inbox, keyID string, privateKey *rsa.PrivateKey, scheme SignatureScheme) error {
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/activity+json")
+	req.Header.Set("Accept", "application/activity+json")
+
 	// Sign request
+	if scheme == SchemeRFC9421 {
+		err = SignRequestRFC9421(req, body, keyID, privateKey)
+	} else {
+		err = SignRequest(req, body, keyID, privateKey)
+	}
+	if err != nil {
+		return err
+	}
+
 	// Send HTTP POST
+	resp, err := deliveryClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
 	// Handle response
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &DeliveryError{
+			Inbox:      inbox,
+			StatusCode: resp.StatusCode,
+			Body:       string(respBody),
+		}
+	}
+
 	return nil
 }
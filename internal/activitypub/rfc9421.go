@@ -0,0 +1,320 @@
+package activitypub
+
+import (
+	"Aervyn/internal/config"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MessageSignature is one entry of the RFC 9421 Signature-Input and
+// Signature headers.
+type MessageSignature struct {
+	Label      string
+	Components []string
+	Params     string // serialized parameters, as used in @signature-params
+	KeyId      string
+	Algorithm  string
+	Created    int64
+	Expires    int64
+	Signature  []byte
+}
+
+// ParseMessageSignature parses the Signature-Input and Signature headers and
+// returns the first signature present in both.
+func ParseMessageSignature(input, signature string) (*MessageSignature, error) {
+	signatures := make(map[string]string)
+	for _, member := range splitTopLevel(signature) {
+		label, value, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+		signatures[strings.TrimSpace(label)] = strings.Trim(strings.TrimSpace(value), ":")
+	}
+
+	for _, member := range splitTopLevel(input) {
+		label, value, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+		label = strings.TrimSpace(label)
+
+		encoded, ok := signatures[label]
+		if !ok {
+			continue
+		}
+
+		sig, err := parseSignatureInput(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		sig.Label = label
+
+		sig.Signature, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode signature: %w", err)
+		}
+		return sig, nil
+	}
+
+	return nil, fmt.Errorf("no matching signature in Signature-Input")
+}
+
+// splitTopLevel splits a structured field dictionary on commas that are not
+// inside a quoted string or an inner list.
+func splitTopLevel(header string) []string {
+	var members []string
+	var current strings.Builder
+	depth, quoted := 0, false
+
+	for i := 0; i < len(header); i++ {
+		c := header[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(header):
+			current.WriteByte(c)
+			i++
+			c = header[i]
+		case c == '"':
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted:
+			depth--
+		case c == ',' && !quoted && depth == 0:
+			members = append(members, strings.TrimSpace(current.String()))
+			current.Reset()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if current.Len() > 0 {
+		members = append(members, strings.TrimSpace(current.String()))
+	}
+	return members
+}
+
+// parseSignatureInput parses a value like
+// ("@method" "@target-uri" "content-digest");created=1;keyid="k";alg="a"
+func parseSignatureInput(value string) (*MessageSignature, error) {
+	if !strings.HasPrefix(value, "(") {
+		return nil, fmt.Errorf("signature input is not an inner list")
+	}
+	end := strings.Index(value, ")")
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated inner list")
+	}
+
+	sig := &MessageSignature{Params: value}
+	for _, item := range strings.Fields(value[1:end]) {
+		sig.Components = append(sig.Components, strings.ToLower(strings.Trim(item, `"`)))
+	}
+
+	for _, param := range strings.Split(value[end+1:], ";") {
+		key, raw, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			continue
+		}
+		unquoted := strings.Trim(raw, `"`)
+		switch key {
+		case "keyid":
+			sig.KeyId = unquoted
+		case "alg":
+			sig.Algorithm = unquoted
+		case "created":
+			sig.Created, _ = strconv.ParseInt(raw, 10, 64)
+		case "expires":
+			sig.Expires, _ = strconv.ParseInt(raw, 10, 64)
+		}
+	}
+
+	if sig.KeyId == "" {
+		return nil, fmt.Errorf("signature input has no keyid")
+	}
+	return sig, nil
+}
+
+// targetURI reconstructs the absolute URI of an incoming request.
+func targetURI(r *http.Request) string {
+	return config.Protocol + "://" + r.Host + r.URL.RequestURI()
+}
+
+// messageSignatureBase builds the RFC 9421 signature base for a request.
+func messageSignatureBase(r *http.Request, targetURI string, components []string, params string) (string, error) {
+	var base strings.Builder
+
+	for _, component := range components {
+		var value string
+		switch component {
+		case "@method":
+			value = r.Method
+		case "@target-uri":
+			value = targetURI
+		case "@authority":
+			value = strings.ToLower(r.Host)
+			if r.Host == "" {
+				value = strings.ToLower(r.URL.Host)
+			}
+		case "@scheme":
+			value = strings.ToLower(strings.SplitN(targetURI, ":", 2)[0])
+		case "@path":
+			value = r.URL.EscapedPath()
+		case "@query":
+			value = "?" + r.URL.RawQuery
+		case "@request-target":
+			value = r.URL.RequestURI()
+		default:
+			if strings.HasPrefix(component, "@") {
+				return "", fmt.Errorf("unsupported derived component %s", component)
+			}
+			values := r.Header.Values(component)
+			if len(values) == 0 {
+				return "", fmt.Errorf("signed header %s is missing", component)
+			}
+			for i := range values {
+				values[i] = strings.TrimSpace(values[i])
+			}
+			value = strings.Join(values, ", ")
+		}
+
+		base.WriteString(fmt.Sprintf("%q: %s\n", component, value))
+	}
+
+	base.WriteString(fmt.Sprintf("%q: %s", "@signature-params", params))
+	return base.String(), nil
+}
+
+// verifyMessageSignature checks an RFC 9421 signature on an incoming request
+// and returns the actor that owns the signing key.
+func verifyMessageSignature(r *http.Request, body []byte) (string, error) {
+	sig, err := ParseMessageSignature(r.Header.Get("Signature-Input"), r.Header.Get("Signature"))
+	if err != nil {
+		return "", fmt.Errorf("invalid signature headers: %w", err)
+	}
+
+	covered := make(map[string]bool)
+	for _, component := range sig.Components {
+		covered[component] = true
+	}
+	if !covered["@method"] {
+		return "", fmt.Errorf("signature does not cover @method")
+	}
+	if !covered["@target-uri"] && !(covered["@authority"] && covered["@path"]) {
+		return "", fmt.Errorf("signature does not cover the target URI")
+	}
+	if r.Method == http.MethodPost && !covered["content-digest"] {
+		return "", fmt.Errorf("signature does not cover content-digest")
+	}
+
+	if sig.Created == 0 {
+		return "", fmt.Errorf("signature has no created parameter")
+	}
+	// created is one of the signature parameters, so it is always signed
+	dated := &SignatureHeader{Headers: []string{"(created)"}, Created: sig.Created, Expires: sig.Expires}
+	if err := verifyDate(r, dated); err != nil {
+		return "", err
+	}
+	if covered["content-digest"] {
+		if err := verifyContentDigest(r.Header.Get("Content-Digest"), body); err != nil {
+			return "", err
+		}
+	}
+
+	base, err := messageSignatureBase(r, targetURI(r), sig.Components, sig.Params)
+	if err != nil {
+		return "", err
+	}
+
+	return verifyWithActorKey(sig.KeyId, func(publicKey *rsa.PublicKey) error {
+		var err error
+		switch sig.Algorithm {
+		case "", "rsa-v1_5-sha256":
+			digest := sha256.Sum256([]byte(base))
+			err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], sig.Signature)
+		case "rsa-pss-sha512":
+			digest := sha512.Sum512([]byte(base))
+			err = rsa.VerifyPSS(publicKey, crypto.SHA512, digest[:], sig.Signature, nil)
+		default:
+			return fmt.Errorf("unsupported signature algorithm %q", sig.Algorithm)
+		}
+		if err != nil {
+			return fmt.Errorf("signature verification failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// verifyContentDigest checks an RFC 9530 Content-Digest header such as
+// sha-256=:base64:, requiring every supported algorithm listed to match.
+func verifyContentDigest(header string, body []byte) error {
+	if header == "" {
+		return fmt.Errorf("no content-digest header")
+	}
+
+	verified := false
+	for _, member := range splitTopLevel(header) {
+		algorithm, value, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+
+		var sum []byte
+		switch strings.TrimSpace(algorithm) {
+		case "sha-256":
+			hash := sha256.Sum256(body)
+			sum = hash[:]
+		case "sha-512":
+			hash := sha512.Sum512(body)
+			sum = hash[:]
+		default:
+			continue
+		}
+
+		if strings.Trim(strings.TrimSpace(value), ":") != base64.StdEncoding.EncodeToString(sum) {
+			return fmt.Errorf("%s content digest does not match body", algorithm)
+		}
+		verified = true
+	}
+
+	if !verified {
+		return fmt.Errorf("no supported algorithm in content-digest %q", header)
+	}
+	return nil
+}
+
+// SignRequestRFC9421 signs an outgoing request with RFC 9421 HTTP Message
+// Signatures, covering the method, target URI and Content-Digest.
+func SignRequestRFC9421(r *http.Request, body []byte, keyID string, privateKey *rsa.PrivateKey) error {
+	bodyHash := sha256.Sum256(body)
+	r.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(bodyHash[:])+":")
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	components := []string{"@method", "@target-uri", "content-digest"}
+	quoted := make([]string, len(components))
+	for i, component := range components {
+		quoted[i] = strconv.Quote(component)
+	}
+	params := fmt.Sprintf(`(%s);created=%d;keyid="%s";alg="rsa-v1_5-sha256"`,
+		strings.Join(quoted, " "), time.Now().Unix(), keyID)
+
+	base, err := messageSignatureBase(r, r.URL.String(), components, params)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256([]byte(base))
+	signature, err := rsa.SignPKCS1v15(nil, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	r.Header.Set("Signature-Input", "sig1="+params)
+	r.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
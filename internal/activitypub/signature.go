@@ -1,24 +1,38 @@
 package activitypub
 
 import (
-	"Aervyn/internal/config"
 	"crypto"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
+const (
+	// Signatures dated further in the future than this are rejected
+	maxClockSkew = time.Hour
+	// Signatures older than this are rejected as replays
+	maxSignatureAge = 12 * time.Hour
+)
+
+var keyFetchClient = &http.Client{Timeout: 15 * time.Second}
+
 type SignatureHeader struct {
 	KeyId     string
 	Algorithm string
 	Headers   []string
 	Signature string
+	Created   int64
+	Expires   int64
 }
 
 func ParseSignatureHeader(header string) (*SignatureHeader, error) {
@@ -38,70 +52,352 @@ func ParseSignatureHeader(header string) (*SignatureHeader, error) {
 		case "algorithm":
 			sig.Algorithm = value
 		case "headers":
-			sig.Headers = strings.Split(value, " ")
+			sig.Headers = strings.Fields(strings.ToLower(value))
 		case "signature":
 			sig.Signature = value
+		case "created":
+			sig.Created, _ = strconv.ParseInt(value, 10, 64)
+		case "expires":
+			sig.Expires, _ = strconv.ParseInt(value, 10, 64)
 		}
 	}
 
+	if sig.KeyId == "" || sig.Signature == "" {
+		return nil, fmt.Errorf("missing keyId or signature")
+	}
+	if len(sig.Headers) == 0 {
+		// The spec defaults to only the Date header, which is not enough
+		sig.Headers = []string{"date"}
+	}
+
 	return sig, nil
 }
 
-func VerifySignature(r *http.Request) error {
-	if config.Development {
-		// Skip signature verification in development
-		return nil
+// VerifySignature checks the HTTP Signature of an incoming request against
+// its body and returns the actor that owns the signing key. Requests with a
+// Signature-Input header are verified as RFC 9421 message signatures, all
+// others as draft-cavage signatures.
+func VerifySignature(r *http.Request, body []byte) (string, error) {
+	if r.Header.Get("Signature-Input") != "" {
+		return verifyMessageSignature(r, body)
 	}
 
 	sigHeader := r.Header.Get("Signature")
 	if sigHeader == "" {
-		return fmt.Errorf("no signature header")
+		return "", fmt.Errorf("no signature header")
 	}
 
 	sig, err := ParseSignatureHeader(sigHeader)
 	if err != nil {
-		return fmt.Errorf("invalid signature header: %w", err)
+		return "", fmt.Errorf("invalid signature header: %w", err)
+	}
+
+	if err := checkSignedHeaders(r, sig); err != nil {
+		return "", err
+	}
+	if err := verifyDate(r, sig); err != nil {
+		return "", err
+	}
+	if err := verifyDigest(r, body); err != nil {
+		return "", err
+	}
+
+	// Verify signature against the actor's public key
+	return verifyWithActorKey(sig.KeyId, func(publicKey *rsa.PublicKey) error {
+		return VerifyHTTPSignature(r, sig, publicKey)
+	})
+}
+
+// checkSignedHeaders makes sure the signature covers what we rely on: the
+// request target, the host, a timestamp and, for requests with a body, the
+// digest.
+func checkSignedHeaders(r *http.Request, sig *SignatureHeader) error {
+	signed := make(map[string]bool)
+	for _, header := range sig.Headers {
+		signed[header] = true
+	}
+
+	if !signed["(request-target)"] {
+		return fmt.Errorf("signature does not cover (request-target)")
+	}
+	if !signed["host"] {
+		return fmt.Errorf("signature does not cover host")
+	}
+	if !signed["date"] && !signed["(created)"] {
+		return fmt.Errorf("signature does not cover date or (created)")
+	}
+	if r.Method == http.MethodPost && !signed["digest"] {
+		return fmt.Errorf("signature does not cover digest")
+	}
+	return nil
+}
+
+// verifyDate rejects signatures outside the allowed clock skew. The created
+// parameter is only trusted when (created) is signed, since otherwise anyone
+// replaying the signature could replace it.
+func verifyDate(r *http.Request, sig *SignatureHeader) error {
+	signsCreated := false
+	for _, header := range sig.Headers {
+		if header == "(created)" {
+			signsCreated = true
+		}
+	}
+
+	var signedAt time.Time
+	if signsCreated {
+		signedAt = time.Unix(sig.Created, 0)
+	} else {
+		date, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			return fmt.Errorf("invalid date header: %w", err)
+		}
+		signedAt = date
+	}
+
+	now := time.Now()
+	if signedAt.After(now.Add(maxClockSkew)) {
+		return fmt.Errorf("signature date %s is in the future", signedAt)
+	}
+	if signedAt.Before(now.Add(-maxSignatureAge)) {
+		return fmt.Errorf("signature date %s is too old", signedAt)
+	}
+	if sig.Expires != 0 && time.Unix(sig.Expires, 0).Before(now) {
+		return fmt.Errorf("signature expired")
+	}
+	return nil
+}
+
+// verifyDigest checks the Digest header against the request body. At least
+// one supported algorithm must be present and every supported one must match.
+func verifyDigest(r *http.Request, body []byte) error {
+	header := r.Header.Get("Digest")
+	if header == "" {
+		if len(body) == 0 && r.Method != http.MethodPost {
+			return nil
+		}
+		return fmt.Errorf("no digest header")
+	}
+
+	verified := false
+	for _, part := range strings.Split(header, ",") {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+
+		var sum []byte
+		switch strings.ToUpper(algorithm) {
+		case "SHA-256":
+			hash := sha256.Sum256(body)
+			sum = hash[:]
+		case "SHA-512":
+			hash := sha512.Sum512(body)
+			sum = hash[:]
+		default:
+			continue
+		}
+
+		if value != base64.StdEncoding.EncodeToString(sum) {
+			return fmt.Errorf("%s digest does not match body", algorithm)
+		}
+		verified = true
+	}
+
+	if !verified {
+		return fmt.Errorf("no supported digest algorithm in %q", header)
+	}
+	return nil
+}
+
+// SignerActor returns the actor URI of the key that signed the request,
+// without verifying the signature.
+func SignerActor(r *http.Request) string {
+	var keyID string
+	if input := r.Header.Get("Signature-Input"); input != "" {
+		sig, err := ParseMessageSignature(input, r.Header.Get("Signature"))
+		if err != nil {
+			return ""
+		}
+		keyID = sig.KeyId
+	} else {
+		sig, err := ParseSignatureHeader(r.Header.Get("Signature"))
+		if err != nil {
+			return ""
+		}
+		keyID = sig.KeyId
 	}
 
-	// Fetch actor's public key
-	actorKey, err := FetchActorKey(sig.KeyId)
+	actor, _, _ := strings.Cut(keyID, "#")
+	return actor
+}
+
+// KeyFetcher resolves a keyId to its PEM encoded public key and owning
+// actor. refresh asks for a fresh copy, bypassing any cache. The models
+// package installs a cached implementation; the default always fetches.
+var KeyFetcher = func(keyID string, refresh bool) (string, string, error) {
+	return FetchActorKeyPem(keyID)
+}
+
+// FetchActorKey fetches and parses the public key identified by keyId and
+// returns it together with the actor that owns it.
+func FetchActorKey(keyId string) (*rsa.PublicKey, string, error) {
+	publicKeyPem, owner, err := FetchActorKeyPem(keyId)
 	if err != nil {
-		return fmt.Errorf("failed to fetch actor key: %w", err)
+		return nil, "", err
 	}
 
-	// Verify signature
-	return VerifyHTTPSignature(r, sig, actorKey)
+	pub, err := ParsePublicKey(publicKeyPem)
+	if err != nil {
+		return nil, "", err
+	}
+	return pub, owner, nil
 }
 
-func FetchActorKey(keyId string) (*rsa.PublicKey, error) {
-	// Fetch actor document
-	resp, err := http.Get(keyId)
+// FetchActorKeyPem fetches the PEM encoded key identified by keyId. keyId
+// may point at the actor document (usually with a #main-key fragment) or
+// at a standalone key. A key only speaks for the actor whose own document
+// publishes it, so the owner it names is fetched and checked as well.
+func FetchActorKeyPem(keyId string) (string, string, error) {
+	doc, err := fetchKeyDocument(keyId)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := doc.PublicKey
+	if doc.PublicKeyPem != "" {
+		key = PublicKey{ID: doc.ID, Owner: doc.Owner, PublicKeyPem: doc.PublicKeyPem}
+	}
+	if key.PublicKeyPem == "" {
+		return "", "", fmt.Errorf("no public key in %s", keyId)
+	}
+
+	owner := key.Owner
+	if owner == "" {
+		owner = doc.ID
+	}
+	if !sameOrigin(owner, keyId) {
+		return "", "", fmt.Errorf("key %s claims owner %s on another origin", keyId, owner)
+	}
+
+	// The actor document itself carried the key
+	if doc.ID == owner && doc.publishes(keyId, key.PublicKeyPem) {
+		return key.PublicKeyPem, owner, nil
+	}
+
+	actor, err := fetchKeyDocument(owner)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch key owner: %w", err)
+	}
+	if actor.ID != owner || !actor.publishes(keyId, key.PublicKeyPem) {
+		return "", "", fmt.Errorf("actor %s does not publish key %s", owner, keyId)
+	}
+	return key.PublicKeyPem, owner, nil
+}
+
+// keyDocument is an actor document or a standalone key.
+type keyDocument struct {
+	ID           string    `json:"id"`
+	Owner        string    `json:"owner"`
+	PublicKeyPem string    `json:"publicKeyPem"`
+	PublicKey    PublicKey `json:"publicKey"`
+}
+
+// publishes reports whether an actor document lists publicKeyPem as the
+// key keyId. Some servers use the bare actor URL as the keyId.
+func (doc *keyDocument) publishes(keyId, publicKeyPem string) bool {
+	if doc.PublicKey.ID != keyId && doc.ID != keyId {
+		return false
+	}
+	return strings.TrimSpace(doc.PublicKey.PublicKeyPem) == strings.TrimSpace(publicKeyPem)
+}
+
+func fetchKeyDocument(uri string) (*keyDocument, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/activity+json")
+
+	resp, err := keyFetchClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	var actor Actor
-	if err := json.NewDecoder(resp.Body).Decode(&actor); err != nil {
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key fetch failed with status %d", resp.StatusCode)
+	}
+
+	var doc keyDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
 		return nil, err
 	}
+	return &doc, nil
+}
+
+// sameOrigin reports whether two URLs share scheme and host.
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Host != "" && ua.Scheme == ub.Scheme && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// verifyWithActorKey runs verify with the key for keyID. If verification
+// fails with a cached key, the key is refetched once in case it was rotated.
+func verifyWithActorKey(keyID string, verify func(*rsa.PublicKey) error) (string, error) {
+	var lastErr error
+	for _, refresh := range []bool{false, true} {
+		publicKeyPem, owner, err := KeyFetcher(keyID, refresh)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to fetch actor key: %w", err)
+			continue
+		}
 
-	// Parse PEM public key
-	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+		publicKey, err := ParsePublicKey(publicKeyPem)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if lastErr = verify(publicKey); lastErr == nil {
+			return owner, nil
+		}
+	}
+	return "", lastErr
+}
+
+// ParsePublicKey decodes a PEM encoded RSA public key in either SPKI
+// ("PUBLIC KEY", as published by Mastodon) or PKCS#1 ("RSA PUBLIC KEY") form.
+func ParsePublicKey(publicKeyPem string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPem))
 	if block == nil {
 		return nil, fmt.Errorf("failed to parse PEM block")
 	}
 
-	pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if pub, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return pub, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
 		return nil, err
 	}
 
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an RSA key")
+	}
 	return pub, nil
 }
 
-func VerifyHTTPSignature(r *http.Request, sig *SignatureHeader, publicKey *rsa.PublicKey) error {
-	// Build string to verify
+// signingString rebuilds the string covered by a draft-cavage signature.
+func signingString(r *http.Request, sig *SignatureHeader) (string, error) {
 	var signatureString strings.Builder
 
 	for i, header := range sig.Headers {
@@ -111,29 +407,141 @@ func VerifyHTTPSignature(r *http.Request, sig *SignatureHeader, publicKey *rsa.P
 
 		switch header {
 		case "(request-target)":
-			path := strings.ToLower(fmt.Sprintf("%s %s", r.Method, r.URL.Path))
-			signatureString.WriteString(fmt.Sprintf("%s: %s", header, path))
+			target := fmt.Sprintf("%s %s", strings.ToLower(r.Method), r.URL.RequestURI())
+			signatureString.WriteString(fmt.Sprintf("%s: %s", header, target))
+		case "(created)":
+			if sig.Created == 0 {
+				return "", fmt.Errorf("(created) signed without created parameter")
+			}
+			signatureString.WriteString(fmt.Sprintf("%s: %d", header, sig.Created))
+		case "(expires)":
+			if sig.Expires == 0 {
+				return "", fmt.Errorf("(expires) signed without expires parameter")
+			}
+			signatureString.WriteString(fmt.Sprintf("%s: %d", header, sig.Expires))
+		case "host":
+			// net/http moves the Host header out of r.Header
+			signatureString.WriteString(fmt.Sprintf("%s: %s", header, r.Host))
 		default:
-			signatureString.WriteString(fmt.Sprintf("%s: %s", header, r.Header.Get(header)))
+			values := r.Header.Values(header)
+			if len(values) == 0 {
+				return "", fmt.Errorf("signed header %s is missing", header)
+			}
+			signatureString.WriteString(fmt.Sprintf("%s: %s", header, strings.Join(values, ", ")))
 		}
 	}
 
+	return signatureString.String(), nil
+}
+
+func VerifyHTTPSignature(r *http.Request, sig *SignatureHeader, publicKey *rsa.PublicKey) error {
+	// Build string to verify
+	signatureString, err := signingString(r, sig)
+	if err != nil {
+		return err
+	}
+
 	// Decode signature
 	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
 	if err != nil {
 		return fmt.Errorf("failed to decode signature: %w", err)
 	}
 
-	// Create hash
-	h := sha256.New()
-	h.Write([]byte(signatureString.String()))
-	digest := h.Sum(nil)
+	switch strings.ToLower(sig.Algorithm) {
+	case "", "rsa-sha256":
+		digest := sha256.Sum256([]byte(signatureString))
+		err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature)
+	case "hs2019":
+		// hs2019 takes the algorithm from the key; for RSA keys the fediverse
+		// uses PKCS#1 v1.5 with SHA-256, while the spec suggests PSS/SHA-512
+		digest := sha256.Sum256([]byte(signatureString))
+		err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature)
+		if err != nil {
+			digest512 := sha512.Sum512([]byte(signatureString))
+			err = rsa.VerifyPSS(publicKey, crypto.SHA512, digest512[:], signature, nil)
+		}
+	case "rsa-sha512":
+		digest := sha512.Sum512([]byte(signatureString))
+		err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA512, digest[:], signature)
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", sig.Algorithm)
+	}
 
 	// Verify signature
-	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest, signature)
 	if err != nil {
 		return fmt.Errorf("signature verification failed: %w", err)
 	}
 
 	return nil
 }
+
+// SignRequest adds Date, Host and Digest headers to an outgoing request and
+// signs it with the given key using the draft-cavage HTTP Signatures scheme.
+func SignRequest(r *http.Request, body []byte, keyID string, privateKey *rsa.PrivateKey) error {
+	bodyHash := sha256.Sum256(body)
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+	r.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	headers := []string{"(request-target)", "host", "date", "digest"}
+
+	var signatureString strings.Builder
+	for i, header := range headers {
+		if i > 0 {
+			signatureString.WriteString("\n")
+		}
+
+		switch header {
+		case "(request-target)":
+			target := r.URL.Path
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			signatureString.WriteString(fmt.Sprintf("%s: %s %s", header, strings.ToLower(r.Method), target))
+		case "host":
+			signatureString.WriteString(fmt.Sprintf("%s: %s", header, r.Host))
+		default:
+			signatureString.WriteString(fmt.Sprintf("%s: %s", header, r.Header.Get(header)))
+		}
+	}
+
+	digest := sha256.Sum256([]byte(signatureString.String()))
+	signature, err := rsa.SignPKCS1v15(nil, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID,
+		strings.Join(headers, " "),
+		base64.StdEncoding.EncodeToString(signature),
+	))
+
+	return nil
+}
+
+// ParsePrivateKey decodes a PEM encoded RSA private key as stored in the users table.
+func ParsePrivateKey(privateKeyPem string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKeyPem))
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse PEM block")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,247 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// stubKeyFetcher serves keys from a map for the duration of a test.
+func stubKeyFetcher(t *testing.T, keys map[string]string) {
+	t.Helper()
+	saved := KeyFetcher
+	KeyFetcher = func(keyID string, refresh bool) (string, string, error) {
+		publicKeyPem, ok := keys[keyID]
+		if !ok {
+			return "", "", errors.New("unknown key")
+		}
+		actor, _, _ := strings.Cut(keyID, "#")
+		return publicKeyPem, actor, nil
+	}
+	t.Cleanup(func() { KeyFetcher = saved })
+}
+
+func TestVerifySignature(t *testing.T) {
+	const keyID = "https://remote.example/users/alice#main-key"
+	key, publicKeyPem := newTestKey(t)
+	otherKey, otherPem := newTestKey(t)
+	stubKeyFetcher(t, map[string]string{
+		keyID: publicKeyPem,
+		"https://remote.example/users/mallory#main-key": otherPem,
+	})
+
+	body := []byte(`{"type":"Follow"}`)
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		keyID   string
+		tamper  func(r *http.Request, body []byte) []byte
+		wantErr bool
+	}{
+		{name: "valid", key: key, keyID: keyID},
+		{
+			name: "body changed", key: key, keyID: keyID,
+			tamper: func(r *http.Request, body []byte) []byte {
+				return []byte(`{"type":"Block"}`)
+			},
+			wantErr: true,
+		},
+		{
+			name: "host changed", key: key, keyID: keyID,
+			tamper: func(r *http.Request, body []byte) []byte {
+				r.Host = "other.example"
+				return body
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed by another key", key: otherKey, keyID: keyID,
+			wantErr: true,
+		},
+		{
+			name: "unknown key", key: key, keyID: "https://remote.example/users/bob#main-key",
+			wantErr: true,
+		},
+		{
+			name: "old date", key: key, keyID: keyID,
+			tamper: func(r *http.Request, body []byte) []byte {
+				r.Header.Set("Date", time.Now().Add(-2*maxSignatureAge).UTC().Format(http.TimeFormat))
+				return body
+			},
+			wantErr: true,
+		},
+		{
+			name: "no signature", key: key, keyID: keyID,
+			tamper: func(r *http.Request, body []byte) []byte {
+				r.Header.Del("Signature")
+				return body
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "https://local.example/users/bob/inbox", bytes.NewReader(body))
+			if err := SignRequest(r, body, tt.keyID, tt.key); err != nil {
+				t.Fatal(err)
+			}
+			received := body
+			if tt.tamper != nil {
+				received = tt.tamper(r, body)
+			}
+
+			actor, err := VerifySignature(r, received)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("VerifySignature() = %q, want error", actor)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifySignature() error: %v", err)
+			}
+			if want := "https://remote.example/users/alice"; actor != want {
+				t.Errorf("VerifySignature() = %q, want %q", actor, want)
+			}
+		})
+	}
+}
+
+func TestFetchActorKeyPem(t *testing.T) {
+	_, alicePem := newTestKey(t)
+	_, strayPem := newTestKey(t)
+
+	docs := make(map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, ok := docs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	defer server.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":           "http://" + r.Host + r.URL.Path,
+			"owner":        server.URL + "/users/alice",
+			"publicKeyPem": strayPem,
+		})
+	}))
+	defer other.Close()
+
+	alice := server.URL + "/users/alice"
+	docs["/users/alice"] = map[string]interface{}{
+		"id":        alice,
+		"publicKey": PublicKey{ID: alice + "#main-key", Owner: alice, PublicKeyPem: alicePem},
+	}
+	carol := server.URL + "/users/carol"
+	docs["/users/carol"] = map[string]interface{}{
+		"id":        carol,
+		"publicKey": PublicKey{ID: server.URL + "/keys/carol", Owner: carol, PublicKeyPem: alicePem},
+	}
+	docs["/keys/carol"] = map[string]interface{}{
+		"id": server.URL + "/keys/carol", "owner": carol, "publicKeyPem": alicePem,
+	}
+	docs["/keys/stray"] = map[string]interface{}{
+		"id": server.URL + "/keys/stray", "owner": alice, "publicKeyPem": strayPem,
+	}
+	docs["/users/eve"] = map[string]interface{}{
+		"id":        server.URL + "/users/eve",
+		"publicKey": PublicKey{ID: server.URL + "/users/eve#main-key", Owner: alice, PublicKeyPem: strayPem},
+	}
+
+	tests := []struct {
+		name      string
+		keyID     string
+		wantOwner string
+		wantErr   bool
+	}{
+		{name: "key on the actor", keyID: alice + "#main-key", wantOwner: alice},
+		{name: "bare actor URL as key", keyID: alice, wantOwner: alice},
+		{name: "key in its own document", keyID: server.URL + "/keys/carol", wantOwner: carol},
+		{name: "key the owner doesn't publish", keyID: server.URL + "/keys/stray", wantErr: true},
+		{name: "actor claiming another owner", keyID: server.URL + "/users/eve#main-key", wantErr: true},
+		{name: "owner on another origin", keyID: other.URL + "/keys/alice", wantErr: true},
+		{name: "missing key", keyID: server.URL + "/keys/none", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publicKeyPem, owner, err := FetchActorKeyPem(tt.keyID)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("FetchActorKeyPem() owner = %q, want error", owner)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FetchActorKeyPem() error: %v", err)
+			}
+			if publicKeyPem != alicePem || owner != tt.wantOwner {
+				t.Errorf("FetchActorKeyPem() = (key matches %t, %q), want (true, %q)", publicKeyPem == alicePem, owner, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestVerifyDate(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * maxSignatureAge)
+
+	tests := []struct {
+		name    string
+		headers []string
+		date    time.Time
+		created time.Time
+		wantErr bool
+	}{
+		{name: "fresh date", headers: []string{"date"}, date: now},
+		{name: "old date", headers: []string{"date"}, date: old, wantErr: true},
+		{name: "old date with unsigned fresh created", headers: []string{"date"}, date: old, created: now, wantErr: true},
+		{name: "signed fresh created", headers: []string{"(created)"}, date: old, created: now},
+		{name: "signed old created", headers: []string{"(created)", "date"}, date: now, created: old, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "https://local.example/users/bob/inbox", nil)
+			r.Header.Set("Date", tt.date.UTC().Format(http.TimeFormat))
+			sig := &SignatureHeader{Headers: tt.headers}
+			if !tt.created.IsZero() {
+				sig.Created = tt.created.Unix()
+			}
+
+			err := verifyDate(r, sig)
+			if tt.wantErr && err == nil {
+				t.Fatal("verifyDate() = nil, want error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("verifyDate() error: %v", err)
+			}
+		})
+	}
+}
@@ -3,17 +3,24 @@ package activitypub
 import "time"
 
 type Actor struct {
-	Context           []string  `json:"@context"`
-	ID                string    `json:"id"`
-	Type              string    `json:"type"`
-	PreferredUsername string    `json:"preferredUsername"`
-	Name              string    `json:"name"`
-	Summary           string    `json:"summary,omitempty"`
-	Inbox             string    `json:"inbox"`
-	Outbox            string    `json:"outbox"`
-	Following         string    `json:"following"`
-	Followers         string    `json:"followers"`
-	PublicKey         PublicKey `json:"publicKey"`
+	Context           []string        `json:"@context"`
+	ID                string          `json:"id"`
+	Type              string          `json:"type"`
+	PreferredUsername string          `json:"preferredUsername"`
+	Name              string          `json:"name"`
+	Summary           string          `json:"summary,omitempty"`
+	Inbox             string          `json:"inbox"`
+	Outbox            string          `json:"outbox,omitempty"`
+	Following         string          `json:"following,omitempty"`
+	Followers         string          `json:"followers,omitempty"`
+	PublicKey         PublicKey       `json:"publicKey"`
+	Endpoints         *ActorEndpoints `json:"endpoints,omitempty"`
+
+	ManuallyApprovesFollowers bool `json:"manuallyApprovesFollowers"`
+}
+
+type ActorEndpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
 }
 
 type PublicKey struct {
@@ -23,13 +30,24 @@ type PublicKey struct {
 }
 
 type OrderedCollection struct {
-	Context      []string   `json:"@context,omitempty"`
-	Type         string     `json:"type"`
-	ID           string     `json:"id"`
-	TotalItems   int        `json:"totalItems"`
-	OrderedItems []Activity `json:"orderedItems,omitempty"`
-	First        string     `json:"first,omitempty"`
-	Last         string     `json:"last,omitempty"`
+	Context      []string    `json:"@context,omitempty"`
+	Type         string      `json:"type"`
+	ID           string      `json:"id,omitempty"`
+	TotalItems   int         `json:"totalItems"`
+	OrderedItems interface{} `json:"orderedItems,omitempty"`
+	First        string      `json:"first,omitempty"`
+	Last         string      `json:"last,omitempty"`
+}
+
+type OrderedCollectionPage struct {
+	Context      []string    `json:"@context,omitempty"`
+	Type         string      `json:"type"`
+	ID           string      `json:"id"`
+	PartOf       string      `json:"partOf"`
+	TotalItems   int         `json:"totalItems"`
+	OrderedItems interface{} `json:"orderedItems"`
+	Next         string      `json:"next,omitempty"`
+	Prev         string      `json:"prev,omitempty"`
 }
 
 type Activity struct {
@@ -40,19 +58,44 @@ type Activity struct {
 	Object    interface{} `json:"object"`
 	To        []string    `json:"to,omitempty"`
 	Cc        []string    `json:"cc,omitempty"`
+	Content   string      `json:"content,omitempty"`
 	Published time.Time   `json:"published"`
 }
 
+// Tombstone stands in for a deleted object.
+type Tombstone struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
 type Note struct {
-	Type         string    `json:"type"`
-	ID           string    `json:"id"`
-	Content      string    `json:"content"`
-	Published    time.Time `json:"published"`
-	AttributedTo string    `json:"attributedTo"`
-	To           []string  `json:"to,omitempty"`
-	Cc           []string  `json:"cc,omitempty"`
+	Context      interface{} `json:"@context,omitempty"`
+	Type         string      `json:"type"`
+	ID           string      `json:"id"`
+	Content      string      `json:"content"`
+	Published    time.Time   `json:"published"`
+	AttributedTo string      `json:"attributedTo"`
+	InReplyTo    *string     `json:"inReplyTo,omitempty"`
+	URL          string      `json:"url,omitempty"`
+	To           []string    `json:"to,omitempty"`
+	Cc           []string    `json:"cc,omitempty"`
+
+	Replies *OrderedCollection `json:"replies,omitempty"`
+	Likes   *OrderedCollection `json:"likes,omitempty"`
+	Shares  *OrderedCollection `json:"shares,omitempty"`
 }
 
 const (
 	PublicAddress = "https://www.w3.org/ns/activitystreams#Public"
 )
+
+// IsPublic reports whether an object addressed to the given recipients is
+// visible to everyone.
+func IsPublic(to, cc []string) bool {
+	for _, recipient := range append(to, cc...) {
+		if recipient == PublicAddress || recipient == "as:Public" || recipient == "Public" {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,18 @@ var (
 	Protocol    = "http"
 	InstanceURL = Protocol + "://" + Domain
 	Development = true
+
+	// Reported to other servers through NodeInfo
+	SoftwareName      = "aervyn"
+	SoftwareVersion   = "0.1.0"
+	NodeName          = "Aervyn"
+	NodeDescription   = "A small ActivityPub server"
+	OpenRegistrations = true
+
+	// In allowlist mode we only federate with AllowedDomains and their
+	// subdomains; everyone else is treated like a suspended domain.
+	AllowlistMode  = false
+	AllowedDomains = []string{}
 )
 
 func GetActorURL(username string) string {
@@ -1,16 +1,20 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"Aervyn/internal/activitypub"
 	"Aervyn/internal/config"
+	"Aervyn/internal/middleware"
 	"Aervyn/internal/models"
 
 	"github.com/go-chi/chi/v5"
@@ -65,6 +69,10 @@ func ActorHandler(w http.ResponseWriter, r *http.Request) {
 		Outbox:            config.GetActorURL(username) + "/outbox",
 		Following:         config.GetActorURL(username) + "/following",
 		Followers:         config.GetActorURL(username) + "/followers",
+		Endpoints: &activitypub.ActorEndpoints{
+			SharedInbox: config.InstanceURL + "/inbox",
+		},
+		ManuallyApprovesFollowers: user.Locked,
 		PublicKey: activitypub.PublicKey{
 			ID:           config.GetActorURL(username) + "#main-key",
 			Owner:        config.GetActorURL(username),
@@ -76,6 +84,41 @@ func ActorHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(actor)
 }
 
+// InstanceActorHandler serves the actor that signs activities sent on
+// behalf of the whole server, such as forwarded reports.
+func InstanceActorHandler(w http.ResponseWriter, r *http.Request) {
+	publicKey, _, err := models.GetInstanceKeys()
+	if err != nil {
+		log.Printf("Error loading instance actor keys: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorURL := models.InstanceActorURL()
+	actor := activitypub.Actor{
+		Context: []string{
+			"https://www.w3.org/ns/activitystreams",
+			"https://w3id.org/security/v1",
+		},
+		ID:                actorURL,
+		Type:              "Application",
+		PreferredUsername: config.Domain,
+		Name:              config.NodeName,
+		Inbox:             config.InstanceURL + "/inbox",
+		Endpoints: &activitypub.ActorEndpoints{
+			SharedInbox: config.InstanceURL + "/inbox",
+		},
+		PublicKey: activitypub.PublicKey{
+			ID:           actorURL + "#main-key",
+			Owner:        actorURL,
+			PublicKeyPem: publicKey,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/activity+json")
+	json.NewEncoder(w).Encode(actor)
+}
+
 func OutboxHandler(w http.ResponseWriter, r *http.Request) {
 	username := chi.URLParam(r, "username")
 
@@ -85,104 +128,377 @@ func OutboxHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get user's posts and convert them to activities
-	posts, err := models.GetPostsByUserID(user.ID)
+	total, err := models.CountOutboxItems(user.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	activities := make([]activitypub.Activity, 0)
-	for _, post := range posts {
-		note := activitypub.Note{
-			Type:         "Note",
-			ID:           fmt.Sprintf("%s/posts/%s", config.InstanceURL, post.ID),
-			Content:      post.Content,
-			Published:    post.CreatedAt,
-			AttributedTo: config.GetActorURL(username),
-			To:           []string{activitypub.PublicAddress},
+	outboxID := fmt.Sprintf("%s/users/%s/outbox", config.InstanceURL, username)
+	w.Header().Set("Content-Type", "application/activity+json")
+
+	query := r.URL.Query()
+	if query.Get("page") != "true" {
+		collection := activitypub.OrderedCollection{
+			Context:    []string{"https://www.w3.org/ns/activitystreams"},
+			Type:       "OrderedCollection",
+			ID:         outboxID,
+			TotalItems: total,
+			First:      outboxID + "?page=true",
+			Last:       outboxID + "?min_id=0&page=true",
 		}
+		json.NewEncoder(w).Encode(collection)
+		return
+	}
+
+	maxID, minID := query.Get("max_id"), query.Get("min_id")
+	items, err := models.GetOutboxItems(user.ID, maxID, minID, collectionPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		activity := activitypub.Activity{
-			ID:        fmt.Sprintf("%s/activities/%s", config.InstanceURL, post.ID),
-			Type:      "Create",
-			Actor:     config.GetActorURL(username),
-			Object:    note,
-			Published: post.CreatedAt,
-			To:        []string{activitypub.PublicAddress},
+	activities := make([]activitypub.Activity, 0, len(items))
+	for _, item := range items {
+		switch item.Type {
+		case "Create":
+			activities = append(activities, models.PostCreateActivity(&item.Post, username))
+		case "Announce":
+			activities = append(activities, models.AnnounceActivity(item.ID, item.Post.ID, username))
 		}
+	}
 
-		activities = append(activities, activity)
+	pageID := outboxID + "?page=true"
+	if maxID != "" {
+		pageID = fmt.Sprintf("%s?max_id=%s&page=true", outboxID, maxID)
+	} else if minID != "" {
+		pageID = fmt.Sprintf("%s?min_id=%s&page=true", outboxID, minID)
 	}
 
-	collection := activitypub.OrderedCollection{
+	page := activitypub.OrderedCollectionPage{
 		Context:      []string{"https://www.w3.org/ns/activitystreams"},
-		Type:         "OrderedCollection",
-		ID:           fmt.Sprintf("%s/users/%s/outbox", config.InstanceURL, username),
-		TotalItems:   len(activities),
+		Type:         "OrderedCollectionPage",
+		ID:           pageID,
+		PartOf:       outboxID,
+		TotalItems:   total,
 		OrderedItems: activities,
 	}
+	if len(items) > 0 {
+		last := items[len(items)-1].ID
+		older, err := models.HasOlderOutboxItems(user.ID, last)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if older {
+			page.Next = fmt.Sprintf("%s?max_id=%s&page=true", outboxID, last)
+		}
+		if maxID != "" || minID != "" {
+			page.Prev = fmt.Sprintf("%s?min_id=%s&page=true", outboxID, items[0].ID)
+		}
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
+
+// wantsActivityJSON reports whether the client asked for an ActivityPub
+// document rather than an HTML page.
+func wantsActivityJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/activity+json") ||
+		strings.Contains(accept, "application/ld+json")
+}
+
+func NoteHandler(w http.ResponseWriter, r *http.Request) {
+	postID := chi.URLParam(r, "postID")
+
+	post, err := models.GetPost(postID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	author, err := models.GetUserByID(post.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	note, err := models.PostNoteWithCollections(post, author.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if wantsActivityJSON(r) {
+		note.Context = "https://www.w3.org/ns/activitystreams"
+		w.Header().Set("Content-Type", "application/activity+json")
+		w.Header().Set("Vary", "Accept")
+		json.NewEncoder(w).Encode(note)
+		return
+	}
+
+	thread, err := models.GetThread(postID)
+	if err != nil {
+		log.Printf("Failed to load thread: %v", err)
+		http.Error(w, "Failed to load post", http.StatusInternalServerError)
+		return
+	}
+
+	currentUserID := middleware.SessionManager.GetString(r.Context(), "userID")
+	if currentUserID != "" {
+		for i := range thread {
+			if !strings.HasPrefix(thread[i].ID, "http") {
+				thread[i].LoadUserInteractions(currentUserID)
+			}
+		}
+	}
+
+	data := map[string]interface{}{
+		"PageTitle":     "Post",
+		"Post":          post,
+		"Posts":         thread,
+		"CurrentUserID": currentUserID,
+	}
+
+	w.Header().Set("Vary", "Accept")
+	renderTemplate(w, "layout.html", data)
+}
+
+func ActivityHandler(w http.ResponseWriter, r *http.Request) {
+	activityID := chi.URLParam(r, "activityID")
+
+	activity, err := models.GetLocalActivity(activityID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Activity not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/activity+json")
+	json.NewEncoder(w).Encode(activity)
+}
+
+const collectionPageSize = 20
+
+func FollowersHandler(w http.ResponseWriter, r *http.Request) {
+	serveFollowCollection(w, r, "followers", models.GetFollowerCount, models.GetFollowerURIs)
+}
+
+func FollowingHandler(w http.ResponseWriter, r *http.Request) {
+	serveFollowCollection(w, r, "following", models.GetFollowingCount, models.GetFollowingURIs)
+}
+
+// serveFollowCollection renders a followers or following OrderedCollection,
+// or one of its pages when ?page=N is given. Users who hide their network
+// only expose the total.
+func serveFollowCollection(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+	count func(string) (int, error),
+	list func(string, int, int) ([]string, error),
+) {
+	username := chi.URLParam(r, "username")
+
+	profile, err := models.GetProfileByUsername(username)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	total, err := count(profile.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	collectionID := config.GetActorURL(profile.Username) + "/" + name
 	w.Header().Set("Content-Type", "application/activity+json")
-	json.NewEncoder(w).Encode(collection)
+
+	pageParam := r.URL.Query().Get("page")
+	if pageParam == "" || profile.HideNetwork {
+		collection := activitypub.OrderedCollection{
+			Context:    []string{"https://www.w3.org/ns/activitystreams"},
+			Type:       "OrderedCollection",
+			ID:         collectionID,
+			TotalItems: total,
+		}
+		if !profile.HideNetwork {
+			collection.First = collectionID + "?page=1"
+		}
+		json.NewEncoder(w).Encode(collection)
+		return
+	}
+
+	page, err := strconv.Atoi(pageParam)
+	if err != nil || page < 1 {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
+
+	items, err := list(profile.ID, collectionPageSize, (page-1)*collectionPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	collectionPage := activitypub.OrderedCollectionPage{
+		Context:      []string{"https://www.w3.org/ns/activitystreams"},
+		Type:         "OrderedCollectionPage",
+		ID:           fmt.Sprintf("%s?page=%d", collectionID, page),
+		PartOf:       collectionID,
+		TotalItems:   total,
+		OrderedItems: items,
+	}
+	if page*collectionPageSize < total {
+		collectionPage.Next = fmt.Sprintf("%s?page=%d", collectionID, page+1)
+	}
+	if page > 1 {
+		collectionPage.Prev = fmt.Sprintf("%s?page=%d", collectionID, page-1)
+	}
+
+	json.NewEncoder(w).Encode(collectionPage)
 }
 
 func InboxHandler(w http.ResponseWriter, r *http.Request) {
 	username := chi.URLParam(r, "username")
 	log.Printf("📥 Received inbox request for user: %s", username)
 
-	body, err := io.ReadAll(r.Body)
+	user, err := models.GetUserByUsername(username)
+	if err != nil {
+		log.Printf("❌ User not found: %v", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	receiveActivity(w, r, user.ID)
+}
+
+// SharedInboxHandler accepts activities for any number of local users at
+// once. They are stored once and routed by follows and addressing.
+func SharedInboxHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("📥 Received shared inbox request")
+	receiveActivity(w, r, "")
+}
+
+// maxActivitySize caps the body of an inbound activity.
+const maxActivitySize = 1 << 20
+
+// receiveActivity verifies and stores an inbound activity for the inbox
+// worker to process. For the shared inbox userID is empty and recipients are
+// resolved from the activity itself.
+func receiveActivity(w http.ResponseWriter, r *http.Request, userID string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxActivitySize))
 	if err != nil {
 		log.Printf("❌ Error reading body: %v", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Activity too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Error reading body", http.StatusBadRequest)
 		return
 	}
-	log.Printf("📨 Received activity: %s", string(body))
 
 	var incomingActivity struct {
-		Type   string      `json:"type"`
-		Actor  string      `json:"actor"`
-		Object interface{} `json:"object"`
-		ID     string      `json:"id"`
+		Type   string          `json:"type"`
+		Actor  string          `json:"actor"`
+		Object json.RawMessage `json:"object"`
+		ID     string          `json:"id"`
 	}
 	if err := json.Unmarshal(body, &incomingActivity); err != nil {
 		log.Printf("❌ Error parsing activity: %v", err)
 		http.Error(w, "Invalid activity", http.StatusBadRequest)
 		return
 	}
+	log.Printf("📨 Received %s %s from %s", incomingActivity.Type, incomingActivity.ID, incomingActivity.Actor)
 
-	user, err := models.GetUserByUsername(username)
+	// Nothing is accepted from suspended or, in allowlist mode, unlisted domains
+	if err := models.CheckFederation(incomingActivity.Actor); err != nil {
+		log.Printf("❌ Rejected activity from %s: %v", incomingActivity.Actor, err)
+		http.Error(w, "Federation with this domain is not allowed", http.StatusForbidden)
+		return
+	}
+
+	// Every inbox delivery must be signed by the actor it claims to be from
+	signer, err := activitypub.VerifySignature(r, body)
 	if err != nil {
-		log.Printf("❌ User not found: %v", err)
-		http.Error(w, "User not found", http.StatusNotFound)
+		log.Printf("❌ Invalid signature: %v", err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if signer != incomingActivity.Actor {
+		log.Printf("❌ Activity actor %s does not match signer %s", incomingActivity.Actor, signer)
+		http.Error(w, "Signer does not match actor", http.StatusUnauthorized)
+		return
+	}
+
+	// Activities are deduplicated by ID, so a sender may only use IDs on
+	// its own host
+	if incomingActivity.ID == "" || !models.SameHost(incomingActivity.ID, incomingActivity.Actor) {
+		log.Printf("❌ Activity ID %q is not on the host of %s", incomingActivity.ID, incomingActivity.Actor)
+		http.Error(w, "Invalid activity ID", http.StatusBadRequest)
 		return
 	}
 
+	var objectID string
+	if object, err := models.ParseObject(incomingActivity.Object); err == nil {
+		objectID = object.ID
+	}
+
 	activity := &models.Activity{
 		ID:        incomingActivity.ID,
-		UserID:    user.ID,
+		UserID:    userID,
 		Type:      incomingActivity.Type,
 		Actor:     incomingActivity.Actor,
-		ObjectID:  fmt.Sprintf("%v", incomingActivity.Object),
+		ObjectID:  objectID,
 		RawData:   string(body),
 		CreatedAt: time.Now(),
 	}
 
+	recipients := []string{userID}
+	if userID == "" {
+		recipients, err = activity.ResolveRecipients()
+		if err != nil {
+			log.Printf("❌ Error resolving recipients: %v", err)
+			http.Error(w, "Invalid activity", http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Store activity
-	if err := models.StoreInboxActivity(activity); err != nil {
+	created, err := models.StoreInboxActivity(activity)
+	if err != nil {
 		log.Printf("❌ Error storing activity: %v", err)
 		http.Error(w, "Error storing activity", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("✅ Stored or updated activity: %s", activity.ID)
 
-	// Process the activity regardless of whether it's new or existing
-	if err := activity.ProcessActivity(); err != nil {
-		log.Printf("❌ Error processing activity: %v", err)
-		http.Error(w, "Error processing activity", http.StatusInternalServerError)
+	added, err := models.AddInboxRecipients(activity.ID, recipients)
+	if err != nil {
+		log.Printf("❌ Error storing recipients: %v", err)
+		http.Error(w, "Error storing activity", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("✅ Processed activity successfully")
+
+	// The same activity often arrives at several inboxes
+	if !created && len(added) == 0 {
+		log.Printf("✅ Already received activity: %s", activity.ID)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	log.Printf("✅ Queued activity %s for %d recipients", activity.ID, len(added))
+
+	// The inbox worker processes it in the background
+	models.WakeInboxWorker()
 
 	w.WriteHeader(http.StatusAccepted)
 }
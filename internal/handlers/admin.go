@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"Aervyn/internal/models"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func DomainBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	renderDomainBlocks(w, "", "")
+}
+
+func renderDomainBlocks(w http.ResponseWriter, message, errMessage string) {
+	blocks, err := models.GetDomainBlocks()
+	if err != nil {
+		log.Printf("Error loading domain blocks: %v", err)
+		http.Error(w, "Error loading domain blocks", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, "layout.html", map[string]interface{}{
+		"PageTitle": "Domain Blocks",
+		"Blocks":    blocks,
+		"Message":   message,
+		"Error":     errMessage,
+	})
+}
+
+// CreateDomainBlockHandler adds or updates a block from the admin form.
+func CreateDomainBlockHandler(w http.ResponseWriter, r *http.Request) {
+	block := &models.DomainBlock{
+		Domain:         r.FormValue("domain"),
+		Severity:       r.FormValue("severity"),
+		RejectMedia:    r.FormValue("rejectMedia") == "on",
+		RejectReports:  r.FormValue("rejectReports") == "on",
+		PublicComment:  r.FormValue("publicComment"),
+		PrivateComment: r.FormValue("privateComment"),
+	}
+
+	if err := models.SaveDomainBlock(block); err != nil {
+		log.Printf("Error saving domain block: %v", err)
+		renderDomainBlocks(w, "", err.Error())
+		return
+	}
+
+	http.Redirect(w, r, "/admin/domain-blocks", http.StatusSeeOther)
+}
+
+func DeleteDomainBlockHandler(w http.ResponseWriter, r *http.Request) {
+	domain := chi.URLParam(r, "domain")
+	if err := models.DeleteDomainBlock(domain); err != nil {
+		log.Printf("Error deleting domain block: %v", err)
+		http.Error(w, "Error deleting domain block", http.StatusInternalServerError)
+		return
+	}
+
+	// htmx swaps the table row out
+	w.WriteHeader(http.StatusOK)
+}
+
+// ExportDomainBlocksHandler downloads all blocks as a blocklist CSV.
+func ExportDomainBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	filename := fmt.Sprintf("domain_blocks_%s.csv", time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	if err := models.ExportDomainBlocks(w); err != nil {
+		log.Printf("Error exporting domain blocks: %v", err)
+	}
+}
+
+// ImportDomainBlocksHandler reads an uploaded blocklist CSV.
+func ImportDomainBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("blocklist")
+	if err != nil {
+		renderDomainBlocks(w, "", "Choose a CSV file to import")
+		return
+	}
+	defer file.Close()
+
+	imported, err := models.ImportDomainBlocks(file)
+	if err != nil {
+		log.Printf("Error importing domain blocks: %v", err)
+		renderDomainBlocks(w, "", fmt.Sprintf("Imported %d blocks before an error: %v", imported, err))
+		return
+	}
+
+	renderDomainBlocks(w, fmt.Sprintf("Imported %d blocks", imported), "")
+}
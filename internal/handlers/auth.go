@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"Aervyn/internal/config"
 	"Aervyn/internal/middleware"
 	"Aervyn/internal/models"
 	"Aervyn/internal/utils"
@@ -28,12 +29,24 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if models.IsSuspended(user.ID) {
+		renderTemplate(w, "layout.html", map[string]interface{}{
+			"PageTitle": "Login",
+			"Error":     "This account has been suspended",
+		})
+		return
+	}
 
 	middleware.SessionManager.Put(r.Context(), "userID", user.ID)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.OpenRegistrations {
+		http.Error(w, "Registrations are closed", http.StatusForbidden)
+		return
+	}
+
 	if r.Method == "GET" {
 		data := map[string]interface{}{
 			"PageTitle": "Register",
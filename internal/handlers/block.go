@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"Aervyn/internal/middleware"
+	"Aervyn/internal/models"
+	"Aervyn/internal/utils"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// resolveTarget turns a username or username@domain from the URL into the
+// actor ID stored in follow, block and mute rows.
+func resolveTarget(r *http.Request) (actor string, isRemote bool, err error) {
+	return resolveHandle(chi.URLParam(r, "username"))
+}
+
+// resolveHandle looks up the actor ID behind @username or
+// @username@domain.
+func resolveHandle(handle string) (actor string, isRemote bool, err error) {
+	identifier := strings.TrimPrefix(handle, "@")
+	identifier, err = utils.ValidateAndNormalizeUsername(identifier)
+	if err != nil {
+		return "", false, err
+	}
+
+	if username, domain, ok := strings.Cut(identifier, "@"); ok {
+		actor, err = models.WebFingerLookup(username, domain)
+		return actor, true, err
+	}
+
+	profile, err := models.GetProfileByUsername(identifier)
+	if err != nil {
+		return "", false, err
+	}
+	return profile.ID, false, nil
+}
+
+func BlockHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.SessionManager.GetString(r.Context(), "userID")
+
+	actor, isRemote, err := resolveTarget(r)
+	if err != nil {
+		log.Printf("Failed to resolve block target: %v", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if actor == userID {
+		http.Error(w, "You can't block yourself", http.StatusBadRequest)
+		return
+	}
+
+	block, err := models.BlockActor(userID, actor)
+	if err != nil {
+		log.Printf("Failed to block %s: %v", actor, err)
+		http.Error(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	if isRemote {
+		if err := models.SendBlock(block); err != nil {
+			log.Printf("Failed to send Block to %s: %v", actor, err)
+		}
+	}
+
+	// Following is gone too, so reload the whole profile
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func UnblockHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.SessionManager.GetString(r.Context(), "userID")
+
+	actor, isRemote, err := resolveTarget(r)
+	if err != nil {
+		log.Printf("Failed to resolve unblock target: %v", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	block, err := models.UnblockActor(userID, actor)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to unblock %s: %v", actor, err)
+		http.Error(w, "Failed to unblock user", http.StatusInternalServerError)
+		return
+	}
+
+	if isRemote && block != nil {
+		if err := models.SendUndoBlock(block); err != nil {
+			log.Printf("Failed to send Undo Block to %s: %v", actor, err)
+		}
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+// MuteHandler mutes an actor. The optional duration is in seconds, and
+// hideNotifications defaults to off.
+func MuteHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.SessionManager.GetString(r.Context(), "userID")
+
+	actor, _, err := resolveTarget(r)
+	if err != nil {
+		log.Printf("Failed to resolve mute target: %v", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var duration time.Duration
+	if seconds, err := strconv.Atoi(r.FormValue("duration")); err == nil && seconds > 0 {
+		duration = time.Duration(seconds) * time.Second
+	}
+	hideNotifications := r.FormValue("hideNotifications") == "on"
+
+	if err := models.MuteActor(userID, actor, hideNotifications, duration); err != nil {
+		log.Printf("Failed to mute %s: %v", actor, err)
+		http.Error(w, "Failed to mute user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func UnmuteHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.SessionManager.GetString(r.Context(), "userID")
+
+	actor, _, err := resolveTarget(r)
+	if err != nil {
+		log.Printf("Failed to resolve unmute target: %v", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := models.UnmuteActor(userID, actor); err != nil {
+		log.Printf("Failed to unmute %s: %v", actor, err)
+		http.Error(w, "Failed to unmute user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
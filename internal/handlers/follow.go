@@ -4,6 +4,8 @@ import (
 	"Aervyn/internal/middleware"
 	"Aervyn/internal/models"
 	"Aervyn/internal/utils"
+	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -59,14 +61,33 @@ func FollowHandler(w http.ResponseWriter, r *http.Request) {
 		actorURI = profile.ID
 	}
 
+	if models.IsBlocked(userID, actorURI) || models.IsBlocked(actorURI, userID) {
+		http.Error(w, "You can't follow this user", http.StatusForbidden)
+		return
+	}
+
 	log.Printf("Creating follow request from %s to actor %s", userID, actorURI)
-	err = models.CreateFollowRequest(userID, actorURI)
+	// Remote follows stay pending until the other server sends Accept, and
+	// local ones until a locked account approves them
+	pending := true
+	if isRemote {
+		err = followRemote(userID, actorURI)
+	} else {
+		var follow *models.Follower
+		follow, err = models.CreateFollowRequest(userID, actorURI, "")
+		pending = err == nil && !follow.Accepted
+	}
 	if err != nil {
 		log.Printf("Failed to create follow request: %v", err)
 		http.Error(w, "Failed to follow user", http.StatusInternalServerError)
 		return
 	}
 
+	label := "Unfollow"
+	if pending {
+		label = "Requested"
+	}
+
 	// Return updated follow button with correct format
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(`
@@ -74,11 +95,41 @@ func FollowHandler(w http.ResponseWriter, r *http.Request) {
                 hx-delete="/follow/@` + targetUsername + `" 
                 hx-target="this" 
                 hx-swap="outerHTML">
-            Unfollow
+            ` + label + `
         </button>
     `))
 }
 
+// followRemote stores a pending follow and queues a Follow activity for the
+// remote actor. The worker resolves their inbox, retrying while their server
+// is down.
+func followRemote(userID, actorURI string) error {
+	follow, err := models.CreatePendingFollow(userID, actorURI)
+	if err != nil {
+		return err
+	}
+
+	return models.SendFollow(follow)
+}
+
+// unfollowRemote removes the follow and queues Undo{Follow} for the remote
+// actor. The follow is removed even when their server can't be reached.
+func unfollowRemote(userID, actorURI string) error {
+	follow, err := models.GetFollow(userID, actorURI)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := models.Unfollow(userID, actorURI); err != nil {
+		return err
+	}
+
+	return models.SendUndoFollow(follow)
+}
+
 func UnfollowHandler(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.SessionManager.GetString(r.Context(), "userID")
 	if userID == "" {
@@ -112,8 +163,14 @@ func UnfollowHandler(w http.ResponseWriter, r *http.Request) {
 		actorURI = profile.ID
 	}
 
-	err := models.Unfollow(userID, actorURI)
+	var err error
+	if isRemote {
+		err = unfollowRemote(userID, actorURI)
+	} else {
+		err = models.Unfollow(userID, actorURI)
+	}
 	if err != nil {
+		log.Printf("Failed to unfollow: %v", err)
 		http.Error(w, "Failed to unfollow user", http.StatusInternalServerError)
 		return
 	}
@@ -129,3 +186,110 @@ func UnfollowHandler(w http.ResponseWriter, r *http.Request) {
         </button>
     `))
 }
+
+// followRequestView is a pending follow with its requester named for display.
+type followRequestView struct {
+	models.Follower
+	Handle string
+}
+
+// requesterHandle returns @username for local requesters and
+// @username@domain for remote ones.
+func requesterHandle(actor string) string {
+	if !strings.HasPrefix(actor, "http") {
+		return actorName(actor)
+	}
+	profile, err := models.FetchRemoteProfile(actor)
+	if err != nil {
+		return actor
+	}
+	return fmt.Sprintf("@%s@%s", profile.Username, profile.Domain)
+}
+
+// FollowRequestsHandler lists follows waiting for the user's approval.
+func FollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.SessionManager.GetString(r.Context(), "userID")
+
+	requests, err := models.GetFollowRequests(userID)
+	if err != nil {
+		log.Printf("Failed to load follow requests: %v", err)
+		http.Error(w, "Failed to load follow requests", http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]followRequestView, 0, len(requests))
+	for _, f := range requests {
+		views = append(views, followRequestView{Follower: f, Handle: requesterHandle(f.UserID)})
+	}
+
+	renderTemplate(w, "layout.html", map[string]interface{}{
+		"PageTitle": "Follow Requests",
+		"Requests":  views,
+	})
+}
+
+// pendingFollowRequest loads a follow request addressed to the current user.
+func pendingFollowRequest(r *http.Request) (*models.Follower, error) {
+	userID := middleware.SessionManager.GetString(r.Context(), "userID")
+	follow, err := models.GetFollowByID(chi.URLParam(r, "followID"))
+	if err != nil {
+		return nil, err
+	}
+	if follow.Actor != userID || follow.Accepted {
+		return nil, sql.ErrNoRows
+	}
+	return follow, nil
+}
+
+// approveFollow accepts a pending follow and tells a remote requester.
+func approveFollow(follow *models.Follower) error {
+	if err := models.AcceptFollowRequest(follow.ID); err != nil {
+		return err
+	}
+	if strings.HasPrefix(follow.UserID, "http") {
+		sendFollowResponse(follow, true)
+	}
+	return nil
+}
+
+func sendFollowResponse(follow *models.Follower, accepted bool) {
+	if err := models.SendFollowResponse(follow, accepted); err != nil {
+		log.Printf("Failed to answer follow request from %s: %v", follow.UserID, err)
+	}
+}
+
+func AcceptFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	follow, err := pendingFollowRequest(r)
+	if err != nil {
+		http.Error(w, "Follow request not found", http.StatusNotFound)
+		return
+	}
+
+	if err := approveFollow(follow); err != nil {
+		log.Printf("Failed to accept follow request %s: %v", follow.ID, err)
+		http.Error(w, "Failed to accept follow request", http.StatusInternalServerError)
+		return
+	}
+
+	// htmx swaps the request out of the list
+	w.WriteHeader(http.StatusOK)
+}
+
+func RejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	follow, err := pendingFollowRequest(r)
+	if err != nil {
+		http.Error(w, "Follow request not found", http.StatusNotFound)
+		return
+	}
+
+	if err := models.RejectFollowRequest(follow.ID); err != nil {
+		log.Printf("Failed to reject follow request %s: %v", follow.ID, err)
+		http.Error(w, "Failed to reject follow request", http.StatusInternalServerError)
+		return
+	}
+	if strings.HasPrefix(follow.UserID, "http") {
+		sendFollowResponse(follow, false)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
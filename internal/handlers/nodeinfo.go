@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"Aervyn/internal/activitypub"
+	"Aervyn/internal/models"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func NodeInfoLinksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(activitypub.NewNodeInfoLinks())
+}
+
+// NodeInfoHandler serves the NodeInfo 2.0 and 2.1 documents.
+func NodeInfoHandler(w http.ResponseWriter, r *http.Request) {
+	version := chi.URLParam(r, "version")
+
+	var schema string
+	switch version {
+	case "2.0":
+		schema = activitypub.NodeInfo20Schema
+	case "2.1":
+		schema = activitypub.NodeInfo21Schema
+	default:
+		http.Error(w, "Unsupported NodeInfo version", http.StatusNotFound)
+		return
+	}
+
+	usage, err := models.GetNodeInfoUsage()
+	if err != nil {
+		log.Printf("Error counting NodeInfo usage: %v", err)
+		http.Error(w, "Error building NodeInfo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", `application/json; profile="`+schema+`#"`)
+	json.NewEncoder(w).Encode(activitypub.NewNodeInfo(version, usage))
+}
+
+// HostMetaHandler serves host-meta as XRD, or as JSON for host-meta.json
+// and clients that ask for it.
+func HostMetaHandler(w http.ResponseWriter, r *http.Request) {
+	hostMeta := activitypub.NewHostMeta()
+
+	if strings.HasSuffix(r.URL.Path, ".json") || strings.Contains(r.Header.Get("Accept"), "json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hostMeta)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xrd+xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(hostMeta)
+}
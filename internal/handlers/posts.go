@@ -3,6 +3,7 @@ package handlers
 import (
 	"Aervyn/internal/middleware"
 	"Aervyn/internal/models"
+	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -21,10 +22,22 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	warnings, err := models.GetAccountWarnings(userID)
+	if err != nil {
+		log.Printf("Error loading warnings for %s: %v", userID, err)
+	}
+	requestCount, err := models.CountFollowRequestNotifications(userID)
+	if err != nil {
+		log.Printf("Error counting follow requests for %s: %v", userID, err)
+	}
+
 	data := map[string]interface{}{
-		"PageTitle":     "Home",
-		"Username":      user.Username,
-		"CurrentUserID": userID,
+		"PageTitle":          "Home",
+		"Username":           user.Username,
+		"CurrentUserID":      userID,
+		"IsAdmin":            user.IsAdmin,
+		"Warnings":           warnings,
+		"FollowRequestCount": requestCount,
 	}
 
 	renderTemplate(w, "layout.html", data)
@@ -68,6 +81,10 @@ func LikeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if id, err := models.GetLikeID(postID, userID); err == nil {
+		go federateInteraction("Like", id, postID, userID, false)
+	}
+
 	// Get updated post
 	post, err := models.GetPost(postID)
 	if err != nil {
@@ -89,12 +106,18 @@ func UnlikeHandler(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.SessionManager.GetString(r.Context(), "userID")
 	postID := chi.URLParam(r, "postID")
 
+	id, idErr := models.GetLikeID(postID, userID)
+
 	err := models.UnlikePost(postID, userID)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
+	if idErr == nil {
+		go federateInteraction("Like", id, postID, userID, true)
+	}
+
 	// Return updated post HTML
 	post, err := models.GetPost(postID)
 	if err != nil {
@@ -122,6 +145,10 @@ func BoostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if id, err := models.GetBoostID(postID, userID); err == nil {
+		go federateInteraction("Announce", id, postID, userID, false)
+	}
+
 	// Return updated post HTML
 	post, err := models.GetPost(postID)
 	if err != nil {
@@ -143,12 +170,18 @@ func UnboostHandler(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.SessionManager.GetString(r.Context(), "userID")
 	postID := chi.URLParam(r, "postID")
 
+	id, idErr := models.GetBoostID(postID, userID)
+
 	err := models.UnboostPost(postID, userID)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
+	if idErr == nil {
+		go federateInteraction("Announce", id, postID, userID, true)
+	}
+
 	// Return updated post HTML
 	post, err := models.GetPost(postID)
 	if err != nil {
@@ -166,6 +199,20 @@ func UnboostHandler(w http.ResponseWriter, r *http.Request) {
 	renderTemplate(w, "post", post)
 }
 
+// federateInteraction sends a Like or Announce, or its Undo, in the
+// background so the button responds without waiting on remote servers.
+func federateInteraction(activityType, id, postID, userID string, undo bool) {
+	var err error
+	if undo {
+		err = models.SendUndoInteraction(activityType, id, postID, userID)
+	} else {
+		err = models.SendInteraction(activityType, id, postID, userID)
+	}
+	if err != nil {
+		log.Printf("Error federating %s of %s: %v", activityType, postID, err)
+	}
+}
+
 func ReplyFormHandler(w http.ResponseWriter, r *http.Request) {
 	postID := chi.URLParam(r, "postID")
 	renderTemplate(w, "reply-form", map[string]interface{}{
@@ -24,6 +24,12 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("Looking up profile: %s", identifier)
 
+	page, err := pageFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var profile *models.Profile
 
 	currentUserID := middleware.SessionManager.GetString(r.Context(), "userID")
@@ -43,14 +49,18 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 		profileURL, err := models.WebFingerLookup(username, domain)
 		if err != nil {
 			log.Printf("WebFinger lookup failed: %v", err)
-			http.Error(w, "Profile not found", http.StatusNotFound)
+			if !federationError(w, err) {
+				http.Error(w, "Profile not found", http.StatusNotFound)
+			}
 			return
 		}
 
 		profile, err = models.FetchRemoteProfile(profileURL)
 		if err != nil {
 			log.Printf("Failed to fetch remote profile: %v", err)
-			http.Error(w, "Failed to fetch profile", http.StatusInternalServerError)
+			if !federationError(w, err) {
+				http.Error(w, "Failed to fetch profile", http.StatusInternalServerError)
+			}
 			return
 		}
 	} else {
@@ -76,19 +86,38 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 		followingCount = 0
 	}
 
-	var isFollowing bool
+	var isFollowing, isRequested, isBlocked bool
+	var mute *models.Mute
 	if currentUserID != "" {
 		isFollowing, err = models.IsFollowing(currentUserID, profile.ID)
 		if err != nil {
 			log.Printf("Failed to check following status: %v", err)
 			isFollowing = false
 		}
+
+		if follow, err := models.GetFollow(currentUserID, profile.ID); err == nil {
+			isRequested = !follow.Accepted
+		}
+
+		isBlocked = models.IsBlocked(currentUserID, profile.ID)
+		mute, _ = models.GetMute(currentUserID, profile.ID)
 	}
-	// Get posts for the profile
-	posts, err := models.GetPostsForProfile(profile)
-	if err != nil {
-		log.Printf("Failed to fetch posts: %v", err)
-		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
+
+	// Get posts for the profile, unless the viewer blocked its owner
+	var posts []models.Post
+	var cursors models.PageCursors
+	if !isBlocked {
+		posts, cursors, err = models.GetPostsForProfile(profile, page)
+		if err != nil {
+			log.Printf("Failed to fetch posts: %v", err)
+			http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Scrolling down the profile loads only more posts
+	if page.MaxID != "" {
+		renderTimeline(w, r, page, posts, cursors, currentUserID)
 		return
 	}
 
@@ -100,6 +129,10 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 		"FollowerCount":  followerCount,
 		"FollowingCount": followingCount,
 		"IsFollowing":    isFollowing,
+		"IsRequested":    isRequested,
+		"IsBlocked":      isBlocked,
+		"Mute":           mute,
+		"NextURL":        setLinkHeader(w, r, cursors),
 	}
 
 	log.Printf("Rendering profile page for: %s", profile.Username)
@@ -142,13 +175,28 @@ func ProfileUpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 	displayName := r.FormValue("displayName")
 	bio := r.FormValue("bio")
+	hideNetwork := r.FormValue("hideNetwork") == "on"
+	locked := r.FormValue("locked") == "on"
 
 	user := &models.User{ID: userID}
-	if err := user.UpdateProfile(displayName, bio); err != nil {
+	if err := user.UpdateProfile(displayName, bio, hideNetwork, locked); err != nil {
 		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
 		return
 	}
 
+	// Unlocking an account approves everyone who was waiting
+	if !locked {
+		requests, err := models.GetFollowRequests(userID)
+		if err != nil {
+			log.Printf("Failed to load follow requests: %v", err)
+		}
+		for i := range requests {
+			if err := approveFollow(&requests[i]); err != nil {
+				log.Printf("Failed to accept follow request %s: %v", requests[i].ID, err)
+			}
+		}
+	}
+
 	profile, err := models.GetProfileByID(userID)
 	if err != nil {
 		log.Printf("Failed to get updated profile: %v", err)
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"Aervyn/internal/models"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -24,7 +25,9 @@ func LookupHandler(w http.ResponseWriter, r *http.Request) {
 	profileURL, err := models.WebFingerLookup(username, domain)
 	if err != nil {
 		log.Printf("WebFinger lookup failed: %v", err)
-		http.Error(w, "Failed to find user", 404)
+		if !federationError(w, err) {
+			http.Error(w, "Failed to find user", 404)
+		}
 		return
 	}
 
@@ -32,10 +35,28 @@ func LookupHandler(w http.ResponseWriter, r *http.Request) {
 	profile, err := models.FetchRemoteProfile(profileURL)
 	if err != nil {
 		log.Printf("Profile fetch failed: %v", err)
-		http.Error(w, "Failed to fetch profile", 500)
+		if !federationError(w, err) {
+			http.Error(w, "Failed to fetch profile", 500)
+		}
 		return
 	}
 
 	// Render profile template
 	renderTemplate(w, "profile", profile)
 }
+
+// federationError reports a domain block or allowlist refusal to the user
+// and returns true, or returns false for any other error.
+func federationError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, models.ErrDomainNotAllowed):
+		http.Error(w, "This instance only federates with an approved list of servers, and this one isn't on it", http.StatusForbidden)
+	case errors.Is(err, models.ErrDomainBlocked):
+		http.Error(w, "This server has been blocked by the instance admins", http.StatusForbidden)
+	case errors.Is(err, models.ErrActorSuspended):
+		http.Error(w, "This account has been suspended by the instance admins", http.StatusForbidden)
+	default:
+		return false
+	}
+	return true
+}
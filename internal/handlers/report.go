@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"Aervyn/internal/middleware"
+	"Aervyn/internal/models"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// reportProfile loads the profile of a report target, which is a local
+// user ID or a remote actor URI. Remote targets must come from ReportTarget
+// or resolveHandle; use knownReportProfile for anything user supplied.
+func reportProfile(target string) (*models.Profile, error) {
+	if strings.HasPrefix(target, "http") {
+		return models.FetchRemoteProfile(target)
+	}
+	return models.GetProfileByID(target)
+}
+
+// knownReportProfile is reportProfile for targets taken from a form, which
+// must already be known here rather than fetched from wherever they point.
+func knownReportProfile(target string) (*models.Profile, error) {
+	if strings.HasPrefix(target, "http") {
+		profile, _, err := models.GetCachedRemoteProfile(target)
+		return profile, err
+	}
+	return models.GetProfileByID(target)
+}
+
+// ReportFormHandler shows the report form for an account (?account=@user
+// or @user@domain) or a post (?post=ID), listing the account's posts so
+// the reporter can pick the offending ones.
+func ReportFormHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.SessionManager.GetString(r.Context(), "userID")
+	postID := r.URL.Query().Get("post")
+
+	var target string
+	var err error
+	if postID != "" {
+		target, err = models.ReportTarget(postID)
+	} else {
+		target, _, err = resolveHandle(r.URL.Query().Get("account"))
+	}
+	if err != nil {
+		if federationError(w, err) {
+			return
+		}
+		log.Printf("Failed to resolve report target: %v", err)
+		http.Error(w, "Nothing to report here", http.StatusNotFound)
+		return
+	}
+	if target == userID {
+		http.Error(w, "You can't report yourself", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := reportProfile(target)
+	if err != nil {
+		if federationError(w, err) {
+			return
+		}
+		log.Printf("Failed to load profile of %s: %v", target, err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	posts, _, err := models.GetPostsForProfile(profile, models.Page{})
+	if err != nil {
+		log.Printf("Failed to load posts of %s: %v", target, err)
+	}
+
+	renderTemplate(w, "layout.html", map[string]interface{}{
+		"PageTitle":    "Report",
+		"Profile":      profile,
+		"Target":       target,
+		"Posts":        posts,
+		"SelectedPost": postID,
+	})
+}
+
+// CreateReportHandler files a report and, if asked, forwards it to the
+// reported account's server.
+func CreateReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.SessionManager.GetString(r.Context(), "userID")
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	limited, err := models.ReportLimitReached(userID)
+	if err != nil {
+		log.Printf("Failed to count reports by %s: %v", userID, err)
+		http.Error(w, "Failed to create report", http.StatusInternalServerError)
+		return
+	}
+	if limited {
+		http.Error(w, "You have filed too many reports recently, please try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	target := r.FormValue("target")
+	profile, err := knownReportProfile(target)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if target == userID {
+		http.Error(w, "You can't report yourself", http.StatusBadRequest)
+		return
+	}
+
+	report := &models.Report{
+		Reporter: userID,
+		Target:   target,
+		Comment:  strings.TrimSpace(r.FormValue("comment")),
+		Forward:  !profile.IsLocal && r.FormValue("forward") == "on",
+	}
+	// Only the target's own posts belong in the report
+	var postIDs []string
+	for _, postID := range r.Form["posts"] {
+		if author, err := models.ReportTarget(postID); err == nil && author == target {
+			postIDs = append(postIDs, postID)
+		}
+	}
+
+	if err := models.CreateReport(report, postIDs); err != nil {
+		log.Printf("Failed to create report: %v", err)
+		http.Error(w, "Failed to create report", http.StatusInternalServerError)
+		return
+	}
+
+	if report.Forward {
+		if err := models.SendFlag(report, postIDs); err != nil {
+			log.Printf("Failed to forward report %s: %v", report.ID, err)
+		}
+	}
+
+	renderTemplate(w, "layout.html", map[string]interface{}{
+		"PageTitle": "Report",
+		"Profile":   profile,
+		"Message":   "Thanks for your report. The moderators will look into it.",
+	})
+}
+
+// reportView is a report with its accounts named for display.
+type reportView struct {
+	models.Report
+	ReporterName string
+	TargetName   string
+	TargetLocal  bool
+}
+
+// actorName turns a local user ID into @username and leaves actor URIs as
+// they are.
+func actorName(actor string) string {
+	if strings.HasPrefix(actor, "http") {
+		return actor
+	}
+	if user, err := models.GetUserByID(actor); err == nil {
+		return "@" + user.Username
+	}
+	return actor
+}
+
+// ReportsHandler shows the moderation queue of open reports.
+func ReportsHandler(w http.ResponseWriter, r *http.Request) {
+	renderReports(w, "")
+}
+
+func renderReports(w http.ResponseWriter, errMessage string) {
+	reports, err := models.GetReports(models.ReportOpen)
+	if err != nil {
+		log.Printf("Error loading reports: %v", err)
+		http.Error(w, "Error loading reports", http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]reportView, 0, len(reports))
+	for _, report := range reports {
+		views = append(views, reportView{
+			Report:       report,
+			ReporterName: actorName(report.Reporter),
+			TargetName:   actorName(report.Target),
+			TargetLocal:  !strings.HasPrefix(report.Target, "http"),
+		})
+	}
+
+	renderTemplate(w, "layout.html", map[string]interface{}{
+		"PageTitle": "Reports",
+		"Reports":   views,
+		"Error":     errMessage,
+	})
+}
+
+// ResolveReportHandler applies the moderator's chosen action to a report.
+func ResolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.SessionManager.GetString(r.Context(), "userID")
+	reportID := chi.URLParam(r, "reportID")
+	action := r.FormValue("action")
+
+	err := models.ResolveReport(reportID, action, userID, strings.TrimSpace(r.FormValue("reason")))
+	if err != nil {
+		log.Printf("Error resolving report %s: %v", reportID, err)
+		renderReports(w, err.Error())
+		return
+	}
+
+	http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+}
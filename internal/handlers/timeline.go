@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"Aervyn/internal/config"
 	"Aervyn/internal/middleware"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"Aervyn/internal/models"
 )
@@ -15,35 +19,103 @@ func FollowingTimelineHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := models.GetFollowingTimeline(userID)
+	page, err := pageFromRequest(r)
 	if err != nil {
-		log.Printf("Failed to get following timeline: %v", err)
-		http.Error(w, "Failed to load timeline", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	data := map[string]interface{}{
-		"Posts":         posts,
-		"CurrentUserID": userID,
+	posts, cursors, err := models.GetFollowingTimeline(userID, page)
+	if err != nil {
+		log.Printf("Failed to get following timeline: %v", err)
+		http.Error(w, "Failed to load timeline", http.StatusInternalServerError)
+		return
 	}
 
-	renderTemplate(w, "timeline", data)
+	renderTimeline(w, r, page, posts, cursors, userID)
 }
 
 func LocalTimelineHandler(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.SessionManager.GetString(r.Context(), "userID")
 
-	posts, err := models.GetLocalTimeline()
+	page, err := pageFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	posts, cursors, err := models.GetLocalTimeline(userID, page)
 	if err != nil {
 		log.Printf("Failed to get local timeline: %v", err)
 		http.Error(w, "Failed to load timeline", http.StatusInternalServerError)
 		return
 	}
 
+	renderTimeline(w, r, page, posts, cursors, userID)
+}
+
+// renderTimeline renders a page of posts. Later pages, loaded as the
+// reader scrolls, are appended in place of the loader that asked for them.
+func renderTimeline(w http.ResponseWriter, r *http.Request, page models.Page, posts []models.Post, cursors models.PageCursors, userID string) {
 	data := map[string]interface{}{
 		"Posts":         posts,
 		"CurrentUserID": userID,
+		"NextURL":       setLinkHeader(w, r, cursors),
+		"Continued":     page.MaxID != "",
 	}
 
+	if page.MaxID != "" {
+		renderTemplate(w, "timeline-page", data)
+		return
+	}
 	renderTemplate(w, "timeline", data)
 }
+
+// pageFromRequest reads the max_id, since_id, min_id and limit parameters
+// of a timeline request.
+func pageFromRequest(r *http.Request) (models.Page, error) {
+	query := r.URL.Query()
+	page := models.Page{
+		MaxID:   query.Get("max_id"),
+		SinceID: query.Get("since_id"),
+		MinID:   query.Get("min_id"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return page, fmt.Errorf("invalid limit %q", limit)
+		}
+		page.Limit = n
+	}
+	return page, nil
+}
+
+// setLinkHeader points API clients at the neighbouring pages, as Mastodon
+// does, and returns the path of the next (older) page, if any.
+func setLinkHeader(w http.ResponseWriter, r *http.Request, cursors models.PageCursors) string {
+	var links []string
+	var next string
+	if cursors.Older != "" {
+		next = pageURL(r, "max_id", cursors.Older)
+		links = append(links, fmt.Sprintf(`<%s%s>; rel="next"`, config.InstanceURL, next))
+	}
+	if cursors.Newer != "" {
+		links = append(links, fmt.Sprintf(`<%s%s>; rel="prev"`, config.InstanceURL, pageURL(r, "min_id", cursors.Newer)))
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	return next
+}
+
+// pageURL is the path and query of the request with its cursor replaced.
+func pageURL(r *http.Request, param, cursor string) string {
+	query := r.URL.Query()
+	query.Del("max_id")
+	query.Del("since_id")
+	query.Del("min_id")
+	query.Set(param, cursor)
+	return r.URL.Path + "?" + query.Encode()
+}
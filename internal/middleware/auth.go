@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"Aervyn/internal/models"
 	"net/http"
 
 	"github.com/alexedwards/scs/v2"
@@ -20,6 +21,25 @@ func RequireAuth(next http.Handler) http.Handler {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
+		// Suspending an account ends its existing sessions too
+		if models.IsSuspended(SessionManager.GetString(r.Context(), "userID")) {
+			SessionManager.Destroy(r.Context())
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin only lets administrators through. It must run after
+// RequireAuth.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := models.GetUserByID(SessionManager.GetString(r.Context(), "userID"))
+		if err != nil || !user.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -1,9 +1,14 @@
 package models
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
+
+	"Aervyn/internal/activitypub"
 )
 
 type Activity struct {
@@ -17,18 +22,20 @@ type Activity struct {
 	Processed bool      `json:"-"`
 }
 
-func StoreInboxActivity(activity *Activity) error {
+// StoreInboxActivity saves an inbound activity once, reporting whether it
+// was new. Activities from the shared inbox have no UserID.
+func StoreInboxActivity(activity *Activity) (bool, error) {
 	var exists bool
 	err := db.QueryRow(
 		"SELECT EXISTS(SELECT 1 FROM inbox_activities WHERE id = ?)",
 		activity.ID,
 	).Scan(&exists)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if exists {
-		return nil
+		return false, nil
 	}
 
 	_, err = db.Exec(`
@@ -45,7 +52,7 @@ func StoreInboxActivity(activity *Activity) error {
 		activity.CreatedAt,
 		false,
 	)
-	return err
+	return err == nil, err
 }
 
 func (a *Activity) ProcessActivity() error {
@@ -53,17 +60,321 @@ func (a *Activity) ProcessActivity() error {
 
 	switch a.Type {
 	case "Follow":
-		return CreateFollowRequest(a.UserID, a.Actor)
+		return a.processFollow()
+	case "Block":
+		return a.processBlock()
+	case "Flag":
+		return a.processFlag()
+	case "Create":
+		return a.processCreate()
+	case "Delete":
+		return a.processDelete()
+	case "Update":
+		return a.processUpdate()
+	case "Accept":
+		return a.processFollowResponse(true)
+	case "Reject":
+		return a.processFollowResponse(false)
 	case "Like":
-		// TODO: Implement like handling
-		return nil
+		return a.processInteraction("likes")
 	case "Announce": // Boost
-		// TODO: Implement boost handling
-		return nil
+		return a.processInteraction("boosts")
 	case "Undo":
-		// TODO: Implement undo handling
-		return nil
+		return a.processUndo()
 	default:
 		return fmt.Errorf("unknown activity type: %s", a.Type)
 	}
 }
+
+// ActivityObject is the part of an embedded object we care about when
+// processing. Objects sent only by reference have just the ID set.
+type ActivityObject struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// ParseObject decodes the object of a raw activity, accepting both an
+// embedded object and a bare ID string.
+func ParseObject(raw json.RawMessage) (*ActivityObject, error) {
+	var id string
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return &ActivityObject{ID: id}, nil
+	}
+
+	var obj ActivityObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (a *Activity) object() (*ActivityObject, error) {
+	var raw struct {
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal([]byte(a.RawData), &raw); err != nil {
+		return nil, err
+	}
+	if len(raw.Object) == 0 {
+		return nil, fmt.Errorf("activity %s has no object", a.ID)
+	}
+	return ParseObject(raw.Object)
+}
+
+// processFollow records a remote follow of a local user. Unlocked
+// accounts accept it right away, locked ones leave it pending until the
+// user approves it.
+func (a *Activity) processFollow() error {
+	if IsBlocked(a.UserID, a.Actor) {
+		log.Printf("Ignoring Follow from blocked actor %s", a.Actor)
+		return nil
+	}
+
+	// Rows read as "user_id follows actor", so the remote follower goes
+	// in user_id and the followed local user in actor.
+	follow, err := CreateFollowRequest(a.Actor, a.UserID, a.ID)
+	if err != nil {
+		return err
+	}
+	if !follow.Accepted {
+		return nil
+	}
+	return SendFollowResponse(follow, true)
+}
+
+// processFollowResponse handles Accept{Follow} and Reject{Follow} for a
+// follow this user sent to the activity's actor.
+func (a *Activity) processFollowResponse(accepted bool) error {
+	obj, err := a.object()
+	if err != nil {
+		return err
+	}
+	if obj.Type != "" && obj.Type != "Follow" {
+		log.Printf("Ignoring %s of %s", a.Type, obj.Type)
+		return nil
+	}
+
+	follow, err := GetFollow(a.UserID, a.Actor)
+	if err == sql.ErrNoRows {
+		log.Printf("No follow from %s to %s to %s", a.UserID, a.Actor, a.Type)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if obj.Actor != "" {
+		actorURL, err := localActorURL(a.UserID)
+		if err != nil {
+			return err
+		}
+		if obj.Actor != actorURL {
+			return fmt.Errorf("%s refers to a follow by %s, not %s", a.Type, obj.Actor, actorURL)
+		}
+	}
+
+	if accepted {
+		if err := AcceptFollowRequest(follow.ID); err != nil {
+			return err
+		}
+		// Backfill the new follow's posts
+		WakeOutboxPoller()
+		return nil
+	}
+	return RejectFollowRequest(follow.ID)
+}
+
+// localPostID extracts the post ID from one of our own Note URIs.
+func localPostID(objectID string) (string, bool) {
+	prefix := activitypub.NoteID("")
+	if !strings.HasPrefix(objectID, prefix) {
+		return "", false
+	}
+	id := strings.TrimPrefix(objectID, prefix)
+	return id, id != "" && !strings.Contains(id, "/")
+}
+
+// processInteraction records a remote Like or Announce of a local post in
+// the likes or boosts table. The row is keyed by the remote activity ID and
+// attributed to the remote actor so a later Undo can find it.
+func (a *Activity) processInteraction(table string) error {
+	obj, err := a.object()
+	if err != nil {
+		return err
+	}
+
+	postID, ok := localPostID(obj.ID)
+	if !ok {
+		log.Printf("Ignoring %s of non-local object %s", a.Type, obj.ID)
+		return nil
+	}
+
+	post, err := GetPost(postID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Printf("Ignoring %s of unknown post %s", a.Type, postID)
+			return nil
+		}
+		return err
+	}
+	if IsBlocked(post.UserID, a.Actor) {
+		log.Printf("Ignoring %s from %s, who is blocked by the author", a.Type, a.Actor)
+		return nil
+	}
+
+	id, err := actorID(a.Actor)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = db.Exec(`
+        INSERT INTO `+table+` (id, user_id, post_id, created_at)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT DO NOTHING
+    `, a.ID, id, postID, now)
+	if err != nil {
+		return err
+	}
+
+	if table == "boosts" {
+		return addToHomeTimelines(postID, post.UserID, id, now)
+	}
+	return nil
+}
+
+// processUndo reverses a Like, Announce or Follow previously sent by the
+// same actor.
+func (a *Activity) processUndo() error {
+	obj, err := a.object()
+	if err != nil {
+		return err
+	}
+
+	if obj.Actor != "" && obj.Actor != a.Actor {
+		return fmt.Errorf("undo by %s of activity owned by %s", a.Actor, obj.Actor)
+	}
+
+	// The object may be a bare ID, so work out its type from what we stored
+	objectType := obj.Type
+	if objectType == "" {
+		objectType = undoTargetType(obj.ID, a.Actor)
+	}
+
+	switch objectType {
+	case "Like":
+		return a.undoInteraction("likes", obj)
+	case "Announce":
+		return a.undoInteraction("boosts", obj)
+	case "Follow":
+		return Unfollow(a.Actor, a.UserID)
+	case "Block":
+		// Blocks we receive leave nothing behind to undo
+		return nil
+	default:
+		log.Printf("Ignoring Undo of %s %s", objectType, obj.ID)
+		return nil
+	}
+}
+
+// undoTargetType guesses the type of an activity referenced only by ID.
+func undoTargetType(id, actor string) string {
+	for table, activityType := range map[string]string{"likes": "Like", "boosts": "Announce"} {
+		var exists bool
+		err := db.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id = ? AND user_id = ?)",
+			id, findActorID(actor),
+		).Scan(&exists)
+		if err == nil && exists {
+			return activityType
+		}
+	}
+
+	var exists bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM inbox_activities WHERE id = ? AND actor = ? AND activity_type = 'Follow')",
+		id, actor,
+	).Scan(&exists)
+	if err == nil && exists {
+		return "Follow"
+	}
+	return ""
+}
+
+func (a *Activity) undoInteraction(table string, obj *ActivityObject) error {
+	actor := findActorID(a.Actor)
+	result, err := db.Exec(
+		"DELETE FROM "+table+" WHERE id = ? AND user_id = ?",
+		obj.ID, actor,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 || len(obj.Object) == 0 {
+		return a.pruneUndoneBoosts(table, actor)
+	}
+
+	// Fall back to the object when the original activity ID is unknown
+	target, err := ParseObject(obj.Object)
+	if err != nil {
+		return err
+	}
+	postID, ok := localPostID(target.ID)
+	if !ok {
+		return nil
+	}
+
+	_, err = db.Exec(
+		"DELETE FROM "+table+" WHERE post_id = ? AND user_id = ?",
+		postID, actor,
+	)
+	if err != nil {
+		return err
+	}
+	return a.pruneUndoneBoosts(table, actor)
+}
+
+// pruneUndoneBoosts takes boosts the actor no longer has out of home
+// timelines after an Undo{Announce}.
+func (a *Activity) pruneUndoneBoosts(table, actor string) error {
+	if table != "boosts" {
+		return nil
+	}
+	_, err := db.Exec(`
+        DELETE FROM home_timeline
+        WHERE boosted_by = ?1
+        AND post_id NOT IN (SELECT post_id FROM boosts WHERE user_id = ?1)
+    `, actor)
+	return err
+}
+
+// processBlock handles a remote actor blocking one of our users by dropping
+// follows in both directions.
+func (a *Activity) processBlock() error {
+	obj, err := a.object()
+	if err != nil {
+		return err
+	}
+
+	actorURL, err := localActorURL(a.UserID)
+	if err != nil {
+		return err
+	}
+	if obj.ID != actorURL {
+		log.Printf("Ignoring Block of %s delivered to %s", obj.ID, actorURL)
+		return nil
+	}
+
+	actor := findActorID(a.Actor)
+	_, err = db.Exec(`
+        DELETE FROM followers
+        WHERE (user_id = ?1 AND actor = ?2) OR (user_id = ?2 AND actor = ?1)
+    `, a.UserID, actor)
+	if err != nil {
+		return err
+	}
+	return unmergeFollow(a.UserID, actor)
+}
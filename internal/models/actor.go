@@ -0,0 +1,234 @@
+package models
+
+import (
+	"Aervyn/internal/config"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Every local user and every remote actor we know about has a row in the
+// actors table. Follows, likes and boosts store the actor's internal ID,
+// which for local users is their user ID, so posts.user_id is an actor ID
+// too, as are the authors of remote posts and the actors named in blocks,
+// mutes, reports and moderation. The model API still takes and returns
+// actor refs: the user ID of a local user or the ActivityPub URI of a
+// remote actor.
+
+// actorID returns the internal ID of an actor ref, adding a placeholder row
+// for remote actors we haven't seen before. Their profile is filled in the
+// next time it is fetched.
+func actorID(ref string) (string, error) {
+	if !strings.HasPrefix(ref, "http") {
+		return ref, nil
+	}
+
+	_, err := db.Exec(`
+        INSERT INTO actors (id, uri, local)
+        VALUES (?, ?, FALSE)
+        ON CONFLICT(uri) DO NOTHING
+    `, uuid.New().String(), ref)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = db.QueryRow("SELECT id FROM actors WHERE uri = ?", ref).Scan(&id)
+	return id, err
+}
+
+// findActorID is like actorID but never adds a row. Unknown remote actors
+// get an empty ID, which matches nothing.
+func findActorID(ref string) string {
+	if !strings.HasPrefix(ref, "http") {
+		return ref
+	}
+
+	var id string
+	db.QueryRow("SELECT id FROM actors WHERE uri = ?", ref).Scan(&id)
+	return id
+}
+
+// actorRef is an SQL expression turning the actor ID in column back into
+// an actor ref.
+func actorRef(column string) string {
+	return fmt.Sprintf(`COALESCE((SELECT CASE WHEN a.local THEN a.id ELSE a.uri END FROM actors a WHERE a.id = %[1]s), %[1]s)`, column)
+}
+
+// moderatedActors is an SQL subquery selecting the actors that blocks,
+// mutes, reports and moderation refer to. Purges keep their actors rows, so
+// an actor who comes back gets the same ID and stays blocked.
+const moderatedActors = `(
+        SELECT actor FROM blocks UNION SELECT actor FROM mutes
+        UNION SELECT actor FROM actor_moderation
+        UNION SELECT reporter FROM reports UNION SELECT target FROM reports
+    )`
+
+// remoteActorIDs is an SQL subquery selecting the IDs of remote actors
+// whose URI matches where, which may use the parameters of the outer query.
+func remoteActorIDs(where string) string {
+	return "(SELECT id FROM actors WHERE NOT local AND " + where + ")"
+}
+
+// addLocalActor gives a local user their row in the actors table.
+func addLocalActor(userID, username string) error {
+	_, err := db.Exec(`
+        INSERT INTO actors (id, uri, local, username, domain)
+        VALUES (?, ?, TRUE, ?, ?)
+        ON CONFLICT DO NOTHING
+    `, userID, config.GetActorURL(username), username, config.Domain)
+	return err
+}
+
+// migrateActors moves cached remote profiles out of the old remote_actors
+// table and rewrites follows, likes, boosts, remote posts, blocks, mutes,
+// reports and moderation that still hold remote actor URIs to use actor
+// IDs. It is safe to run on every start.
+func migrateActors() error {
+	rows, err := db.Query("SELECT id, username FROM users")
+	if err != nil {
+		return err
+	}
+	var users [][2]string
+	for rows.Next() {
+		var user [2]string
+		if err := rows.Scan(&user[0], &user[1]); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	for _, user := range users {
+		if err := addLocalActor(user[0], user[1]); err != nil {
+			return err
+		}
+	}
+
+	var legacy bool
+	err = db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'remote_actors')",
+	).Scan(&legacy)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		if err := migrateRemoteActors(); err != nil {
+			return err
+		}
+	}
+
+	// Remote actors named in follows, likes, boosts and the rest
+	rows, err = db.Query(`
+        SELECT user_id FROM followers WHERE user_id LIKE 'http%'
+        UNION SELECT actor FROM followers WHERE actor LIKE 'http%'
+        UNION SELECT user_id FROM likes WHERE user_id LIKE 'http%'
+        UNION SELECT user_id FROM boosts WHERE user_id LIKE 'http%'
+        UNION SELECT author FROM remote_posts WHERE author LIKE 'http%'
+        UNION SELECT actor FROM blocks WHERE actor LIKE 'http%'
+        UNION SELECT actor FROM mutes WHERE actor LIKE 'http%'
+        UNION SELECT reporter FROM reports WHERE reporter LIKE 'http%'
+        UNION SELECT target FROM reports WHERE target LIKE 'http%'
+        UNION SELECT actor FROM actor_moderation WHERE actor LIKE 'http%'
+    `)
+	if err != nil {
+		return err
+	}
+	var uris []string
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			rows.Close()
+			return err
+		}
+		uris = append(uris, uri)
+	}
+	rows.Close()
+	if len(uris) == 0 {
+		return nil
+	}
+
+	for _, uri := range uris {
+		if _, err := actorID(uri); err != nil {
+			return err
+		}
+	}
+
+	for _, stmt := range []string{
+		"UPDATE OR IGNORE followers SET user_id = (SELECT id FROM actors WHERE uri = followers.user_id) WHERE user_id LIKE 'http%'",
+		"UPDATE OR IGNORE followers SET actor = (SELECT id FROM actors WHERE uri = followers.actor) WHERE actor LIKE 'http%'",
+		"UPDATE likes SET user_id = (SELECT id FROM actors WHERE uri = likes.user_id) WHERE user_id LIKE 'http%'",
+		"UPDATE boosts SET user_id = (SELECT id FROM actors WHERE uri = boosts.user_id) WHERE user_id LIKE 'http%'",
+		"UPDATE remote_posts SET author = (SELECT id FROM actors WHERE uri = remote_posts.author) WHERE author LIKE 'http%'",
+		"UPDATE OR IGNORE blocks SET actor = (SELECT id FROM actors WHERE uri = blocks.actor) WHERE actor LIKE 'http%'",
+		"UPDATE OR IGNORE mutes SET actor = (SELECT id FROM actors WHERE uri = mutes.actor) WHERE actor LIKE 'http%'",
+		"UPDATE reports SET reporter = (SELECT id FROM actors WHERE uri = reports.reporter) WHERE reporter LIKE 'http%'",
+		"UPDATE reports SET target = (SELECT id FROM actors WHERE uri = reports.target) WHERE target LIKE 'http%'",
+		"UPDATE OR IGNORE actor_moderation SET actor = (SELECT id FROM actors WHERE uri = actor_moderation.actor) WHERE actor LIKE 'http%'",
+		// Duplicates the unique constraints kept from being rewritten
+		"DELETE FROM followers WHERE user_id LIKE 'http%' OR actor LIKE 'http%'",
+		"DELETE FROM blocks WHERE actor LIKE 'http%'",
+		"DELETE FROM mutes WHERE actor LIKE 'http%'",
+		"DELETE FROM actor_moderation WHERE actor LIKE 'http%'",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRemoteActors copies the old remote_actors cache into actors and
+// drops it.
+func migrateRemoteActors() error {
+	// Caches from before these columns existed
+	if err := addColumn("remote_actors", "icon", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumn("remote_actors", "key_id", "TEXT"); err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT id FROM remote_actors")
+	if err != nil {
+		return err
+	}
+	var uris []string
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			rows.Close()
+			return err
+		}
+		uris = append(uris, uri)
+	}
+	rows.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, uri := range uris {
+		_, err := tx.Exec(`
+            INSERT INTO actors
+            (id, uri, local, username, domain, display_name, bio, public_key,
+             inbox, shared_inbox, outbox, icon, key_id, fetched_at)
+            SELECT ?, id, FALSE, username, domain, display_name, bio, public_key,
+                   inbox, shared_inbox, outbox, icon, key_id, fetched_at
+            FROM remote_actors
+            WHERE id = ?
+            ON CONFLICT(uri) DO NOTHING
+        `, uuid.New().String(), uri)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DROP TABLE remote_actors"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
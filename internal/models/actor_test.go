@@ -0,0 +1,131 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrateActorColumns(t *testing.T) {
+	user, err := CreateUser("mig_alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const uri = "https://remote.example/users/mig_zed"
+
+	// Rows written before these columns held actor IDs, including a mute
+	// that was already rewritten once and would collide with the old row
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO remote_posts (id, author, content, published) VALUES (?, ?, '', ?)`,
+			[]interface{}{"https://remote.example/notes/mig-1", uri, time.Now()}},
+		{`INSERT INTO blocks (id, user_id, actor) VALUES ('mig-block', ?, ?)`,
+			[]interface{}{user.ID, uri}},
+		{`INSERT INTO mutes (id, user_id, actor) VALUES ('mig-mute', ?, ?)`,
+			[]interface{}{user.ID, uri}},
+		{`INSERT INTO reports (id, reporter, target) VALUES ('mig-report', ?, ?)`,
+			[]interface{}{uri, user.ID}},
+		{`INSERT INTO actor_moderation (actor, severity) VALUES (?, 'silence')`,
+			[]interface{}{uri}},
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id, err := actorID(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO mutes (id, user_id, actor) VALUES ('mig-mute-2', ?, ?)`, user.ID, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateActors(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"remote post author", "SELECT author FROM remote_posts WHERE id = 'https://remote.example/notes/mig-1'", []string{id}},
+		{"block", "SELECT actor FROM blocks WHERE user_id = ?1", []string{id}},
+		{"mutes without duplicates", "SELECT actor FROM mutes WHERE user_id = ?1", []string{id}},
+		{"reporter", "SELECT reporter FROM reports WHERE id = 'mig-report'", []string{id}},
+		{"moderation", "SELECT actor FROM actor_moderation WHERE actor LIKE '%mig_zed' OR actor = ?2", []string{id}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := db.Query(tt.query, user.ID, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var got []string
+			for rows.Next() {
+				var v string
+				if err := rows.Scan(&v); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, v)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The model API still speaks actor refs
+	if !IsBlocked(user.ID, uri) {
+		t.Error("IsBlocked() = false after migration")
+	}
+	if block, err := GetBlock(user.ID, uri); err != nil || block.Actor != uri {
+		t.Errorf("GetBlock() = %v, %v, want actor %q", block, err, uri)
+	}
+	if report, err := GetReport("mig-report"); err != nil || report.Reporter != uri || report.Target != user.ID {
+		t.Errorf("GetReport() = %v, %v, want reporter %q", report, err, uri)
+	}
+	if !IsSilenced(uri) {
+		t.Error("IsSilenced() = false after migration")
+	}
+
+	// Purging the actor keeps its ID, so the block outlives the purge
+	if err := PurgeRemoteActor(uri); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := actorID(uri); err != nil || again != id {
+		t.Errorf("actorID() after purge = %q, %v, want %q", again, err, id)
+	}
+	if !IsBlocked(user.ID, uri) {
+		t.Error("IsBlocked() = false after purge")
+	}
+}
+
+func TestResolveActorKeyIgnoresOtherActorsKeyIDs(t *testing.T) {
+	const victim, mallory = "https://victim.example/users/vic", "https://evil.example/users/mallory"
+	const keyID = victim + "#main-key"
+
+	// mallory's document claims the victim's keyId for its own key
+	profiles := []*Profile{
+		{ID: mallory, PublicKey: "mallory-key", KeyID: keyID},
+		{ID: victim, PublicKey: "victim-key", KeyID: keyID},
+	}
+	for _, p := range profiles {
+		if err := StoreRemoteProfile(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range []string{keyID, victim} {
+		publicKey, owner, err := resolveActorKey(id, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if publicKey != "victim-key" || owner != victim {
+			t.Errorf("resolveActorKey(%q) = %q, %q, want victim-key, %q", id, publicKey, owner, victim)
+		}
+	}
+}
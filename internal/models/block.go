@@ -0,0 +1,231 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Block rows read as "user_id blocks actor", like followers rows, and store
+// the actor's ID. Actor is returned as an actor ref.
+type Block struct {
+	ID        string
+	UserID    string
+	Actor     string
+	CreatedAt time.Time
+}
+
+// Mute hides an actor's posts from a user's timelines without telling
+// them. A zero ExpiresAt means the mute lasts until removed, and
+// HideNotifications also keeps their follow requests out of the count the
+// user is shown.
+type Mute struct {
+	ID                string
+	UserID            string
+	Actor             string
+	HideNotifications bool
+	ExpiresAt         *time.Time
+	CreatedAt         time.Time
+}
+
+// BlockActor records a block and removes follows in both directions. An
+// existing block is returned unchanged.
+func BlockActor(userID, actor string) (*Block, error) {
+	blockedID, err := actorID(actor)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO blocks (id, user_id, actor, created_at)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT(user_id, actor) DO NOTHING
+    `, uuid.New().String(), userID, blockedID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+        DELETE FROM followers
+        WHERE (user_id = ?1 AND actor = ?2) OR (user_id = ?2 AND actor = ?1)
+    `, userID, blockedID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+        DELETE FROM home_timeline
+        WHERE user_id = ?1 AND (author_id = ?2 OR boosted_by = ?2)
+    `, userID, blockedID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetBlock(userID, actor)
+}
+
+// UnblockActor removes a block and returns it, or sql.ErrNoRows if there
+// was none.
+func UnblockActor(userID, actor string) (*Block, error) {
+	block, err := GetBlock(userID, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec("DELETE FROM blocks WHERE id = ?", block.ID)
+	return block, err
+}
+
+func GetBlock(userID, actor string) (*Block, error) {
+	var b Block
+	err := db.QueryRow(`
+        SELECT id, user_id, `+actorRef("actor")+`, created_at
+        FROM blocks
+        WHERE user_id = ? AND actor = ?
+    `, userID, findActorID(actor)).Scan(&b.ID, &b.UserID, &b.Actor, &b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func IsBlocked(userID, actor string) bool {
+	var exists bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM blocks WHERE user_id = ? AND actor = ?)",
+		userID, findActorID(actor),
+	).Scan(&exists)
+	return err == nil && exists
+}
+
+// MuteActor mutes an actor for the given duration, or indefinitely when
+// duration is zero. Muting again replaces the previous settings.
+func MuteActor(userID, actor string, hideNotifications bool, duration time.Duration) error {
+	var expiresAt *time.Time
+	if duration > 0 {
+		t := time.Now().Add(duration)
+		expiresAt = &t
+	}
+
+	mutedID, err := actorID(actor)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+        INSERT INTO mutes (id, user_id, actor, hide_notifications, expires_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(user_id, actor) DO UPDATE SET
+        hide_notifications = excluded.hide_notifications,
+        expires_at = excluded.expires_at
+    `, uuid.New().String(), userID, mutedID, hideNotifications, expiresAt, time.Now())
+	return err
+}
+
+func UnmuteActor(userID, actor string) error {
+	_, err := db.Exec("DELETE FROM mutes WHERE user_id = ? AND actor = ?", userID, findActorID(actor))
+	return err
+}
+
+// GetMute returns an active mute, or sql.ErrNoRows if the actor is not
+// muted or the mute has expired.
+func GetMute(userID, actor string) (*Mute, error) {
+	var m Mute
+	var expiresAt sql.NullTime
+	err := db.QueryRow(`
+        SELECT id, user_id, `+actorRef("actor")+`, hide_notifications, expires_at, created_at
+        FROM mutes
+        WHERE user_id = ? AND actor = ?
+        AND (expires_at IS NULL OR expires_at > ?)
+    `, userID, findActorID(actor), time.Now()).Scan(&m.ID, &m.UserID, &m.Actor, &m.HideNotifications, &expiresAt, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		m.ExpiresAt = &expiresAt.Time
+	}
+	return &m, nil
+}
+
+// notificationMutes selects the actors user ?1 has an active mute on with
+// notifications hidden, as of time ?2.
+const notificationMutes = `
+            SELECT actor FROM mutes
+            WHERE user_id = ?1 AND hide_notifications = TRUE
+            AND (expires_at IS NULL OR expires_at > ?2)`
+
+// followedActorIDs selects user ?1 and the IDs of the actors they follow.
+const followedActorIDs = `
+            SELECT ?1
+            UNION
+            SELECT actor FROM followers WHERE user_id = ?1 AND accepted = TRUE`
+
+// hiddenActorIDs selects the IDs of the actors hidden from user ?1 as of
+// time ?2, as listed by hiddenActors.
+const hiddenActorIDs = `
+            SELECT actor FROM blocks WHERE user_id = ?1
+            UNION
+            SELECT actor FROM mutes
+            WHERE user_id = ?1 AND (expires_at IS NULL OR expires_at > ?2)
+            UNION
+            SELECT actor FROM actor_moderation
+            WHERE severity = 'suspend' OR actor NOT IN (` + followedActorIDs + `)`
+
+// hiddenActors returns the actors whose posts a user never wants to see:
+// everyone they block or have an active mute on, plus accounts suspended by
+// moderators. Silenced accounts stay visible to themselves and their
+// followers.
+func hiddenActors(userID string) (map[string]bool, error) {
+	rows, err := db.Query(`
+        SELECT `+actorRef("actor")+` FROM (`+hiddenActorIDs+`
+        )
+    `, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hidden := make(map[string]bool)
+	for rows.Next() {
+		var actor string
+		if err := rows.Scan(&actor); err != nil {
+			return nil, err
+		}
+		hidden[actor] = true
+	}
+	return hidden, rows.Err()
+}
+
+// filterHidden drops posts by blocked or muted actors from a timeline,
+// along with replies to them.
+func filterHidden(userID string, posts []Post) ([]Post, error) {
+	hidden, err := hiddenActors(userID)
+	if err != nil || len(hidden) == 0 {
+		return posts, err
+	}
+
+	hiddenPosts := make(map[string]bool)
+	visible := make([]Post, 0, len(posts))
+	for _, p := range posts {
+		author := p.Author.ID
+		if author == "" {
+			author = p.AuthorID
+		}
+		boosted := p.BoostedBy != nil && hidden[p.BoostedBy.ID]
+		if hidden[author] || boosted || (p.ReplyTo != nil && hiddenPosts[*p.ReplyTo]) {
+			hiddenPosts[p.ID] = true
+			continue
+		}
+		visible = append(visible, p)
+	}
+	return visible, nil
+}
@@ -0,0 +1,45 @@
+package models
+
+import "testing"
+
+func TestCountFollowRequestNotifications(t *testing.T) {
+	users := make(map[string]string)
+	for _, name := range []string{"nt_alice", "nt_bob", "nt_carol", "nt_dave"} {
+		user, err := CreateUser(name, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user.ID
+	}
+	alice := users["nt_alice"]
+
+	// bob, carol and dave all ask to follow alice; she has muted carol
+	// with notifications hidden and dave with them shown
+	for _, name := range []string{"nt_bob", "nt_carol", "nt_dave"} {
+		if _, err := CreatePendingFollow(users[name], alice); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := MuteActor(alice, users["nt_carol"], true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := MuteActor(alice, users["nt_dave"], false, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := CountFollowRequestNotifications(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("CountFollowRequestNotifications() = %d, want 2", count)
+	}
+
+	requests, err := GetFollowRequests(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 3 {
+		t.Errorf("GetFollowRequests() returned %d requests, want 3", len(requests))
+	}
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"Aervyn/internal/activitypub"
 	"database/sql"
+	"fmt"
 )
 
 var db *sql.DB
@@ -42,6 +44,16 @@ func InitDB() error {
 		return err
 	}
 
+	if err = addColumn("users", "hide_network", "BOOLEAN DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if err = addColumn("users", "is_admin", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if err = addColumn("users", "locked", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+
 	// Likes table
 	_, err = db.Exec(`
         CREATE TABLE IF NOT EXISTS likes (
@@ -92,6 +104,58 @@ func InitDB() error {
 		return err
 	}
 
+	// Local users an inbox activity was delivered to. Activities arriving
+	// at the shared inbox are stored once and fanned out through this table.
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS inbox_recipients (
+            activity_id TEXT NOT NULL,
+            user_id TEXT NOT NULL,
+            PRIMARY KEY (activity_id, user_id)
+        )
+    `)
+	if err != nil {
+		return err
+	}
+
+	// Inbound activities are processed by the inbox worker, which retries
+	// failures and dead-letters those that keep failing.
+	queued, err := hasColumn("inbox_activities", "attempts")
+	if err != nil {
+		return err
+	}
+	if err = addColumn("inbox_activities", "attempts", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = addColumn("inbox_activities", "next_attempt_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err = addColumn("inbox_activities", "last_error", "TEXT"); err != nil {
+		return err
+	}
+	if err = addColumn("inbox_activities", "failed", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if err = addColumn("inbox_recipients", "processed", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if !queued {
+		// Everything received before the worker existed was processed inline
+		if _, err = db.Exec("UPDATE inbox_activities SET processed = TRUE"); err != nil {
+			return err
+		}
+		if _, err = db.Exec("UPDATE inbox_recipients SET processed = TRUE"); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_inbox_activities_due
+	ON inbox_activities(failed, processed, next_attempt_at)
+`)
+	if err != nil {
+		return err
+	}
+
 	// Create followers table
 	_, err = db.Exec(`
 	CREATE TABLE IF NOT EXISTS followers (
@@ -108,5 +172,377 @@ func InitDB() error {
 		return err
 	}
 
+	// The ID of a remote Follow, which our Accept or Reject must refer to.
+	// Databases without it still hold inbound follows the wrong way round.
+	hadActivityID, err := hasColumn("followers", "activity_id")
+	if err != nil {
+		return err
+	}
+	if err = addColumn("followers", "activity_id", "TEXT"); err != nil {
+		return err
+	}
+	if !hadActivityID {
+		if err := migrateInboundFollows(); err != nil {
+			return err
+		}
+	}
+
+	// Create remote_posts table (Notes received from other servers, by the
+	// author's actor ID). visibility is worked out from the addressing.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS remote_posts (
+		id TEXT PRIMARY KEY,
+		author TEXT NOT NULL,
+		content TEXT NOT NULL,
+		in_reply_to TEXT,
+		published TIMESTAMP,
+		url TEXT,
+		to_addresses TEXT,
+		cc_addresses TEXT,
+		visibility TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP,
+		deleted_at TIMESTAMP
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	if err = addColumn("remote_posts", "visibility", "TEXT"); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_remote_posts_author
+	ON remote_posts(author, published)
+`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_remote_posts_in_reply_to
+	ON remote_posts(in_reply_to)
+`)
+	if err != nil {
+		return err
+	}
+
+	// Create actors table (local users and cached remote profiles)
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS actors (
+		id TEXT PRIMARY KEY,
+		uri TEXT NOT NULL UNIQUE,
+		local BOOLEAN NOT NULL DEFAULT FALSE,
+		username TEXT,
+		domain TEXT,
+		display_name TEXT,
+		bio TEXT,
+		public_key TEXT,
+		inbox TEXT,
+		shared_inbox TEXT,
+		outbox TEXT,
+		icon TEXT,
+		key_id TEXT,
+		fetched_at TIMESTAMP
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	// Create outbox_polls table (when to next read each followed actor's outbox)
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS outbox_polls (
+		actor_id TEXT PRIMARY KEY,
+		etag TEXT,
+		last_modified TEXT,
+		interval_seconds INTEGER NOT NULL DEFAULT 0,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		polled_at TIMESTAMP,
+		next_poll_at TIMESTAMP,
+		FOREIGN KEY(actor_id) REFERENCES actors(id)
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_outbox_polls_due
+	ON outbox_polls(next_poll_at)
+`)
+	if err != nil {
+		return err
+	}
+	activitypub.KeyFetcher = resolveActorKey
+
+	// Create peers table (what we know about other servers)
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS peers (
+		host TEXT PRIMARY KEY,
+		signature_scheme TEXT,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)
+`)
+	if err != nil {
+		return err
+	}
+	activitypub.PeerSchemes = peerSchemeStore{}
+
+	// Create deliveries table (outgoing activity queue). An empty inbox is
+	// looked up from the recipient actor when the delivery is attempted,
+	// using their sharedInbox if shared is set.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS deliveries (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		inbox TEXT NOT NULL,
+		recipient TEXT,
+		shared BOOLEAN NOT NULL DEFAULT FALSE,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	if err = addColumn("deliveries", "recipient", "TEXT"); err != nil {
+		return err
+	}
+	if err = addColumn("deliveries", "shared", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_deliveries_due
+	ON deliveries(status, next_attempt_at)
+`)
+	if err != nil {
+		return err
+	}
+
+	// Activities that exist only as delivery payloads are served by ID
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_deliveries_activity
+	ON deliveries(json_extract(payload, '$.id'))
+`)
+	if err != nil {
+		return err
+	}
+
+	// Create blocks and mutes tables. Rows read as "user_id blocks/mutes
+	// actor", where actor is an actor ID.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS blocks (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_id, actor)
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mutes (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		hide_notifications BOOLEAN NOT NULL DEFAULT TRUE,
+		expires_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_id, actor)
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	// Key pair of the instance actor, which signs activities sent on
+	// behalf of the whole server
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS instance_actor (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		public_key TEXT NOT NULL,
+		private_key TEXT NOT NULL
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	// Create reports tables. reporter and target are actor IDs; post_id is
+	// a local post ID or remote object URI.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS reports (
+		id TEXT PRIMARY KEY,
+		reporter TEXT NOT NULL,
+		target TEXT NOT NULL,
+		comment TEXT,
+		forward BOOLEAN NOT NULL DEFAULT FALSE,
+		remote_id TEXT UNIQUE,
+		status TEXT NOT NULL DEFAULT 'open',
+		action TEXT,
+		resolved_by TEXT,
+		resolved_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS report_posts (
+		report_id TEXT NOT NULL,
+		post_id TEXT NOT NULL,
+		PRIMARY KEY (report_id, post_id),
+		FOREIGN KEY(report_id) REFERENCES reports(id)
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS account_warnings (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		report_id TEXT,
+		text TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	// Account-level moderation by the admins, for local and remote actors
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS actor_moderation (
+		actor TEXT PRIMARY KEY,
+		severity TEXT NOT NULL,
+		report_id TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	if err = migrateActors(); err != nil {
+		return err
+	}
+
+	if err = migrateVisibility(); err != nil {
+		return err
+	}
+
+	// Create home_timeline table (each user's following timeline, filled on write)
+	var timelineExists bool
+	err = db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'home_timeline')",
+	).Scan(&timelineExists)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS home_timeline (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		post_id TEXT NOT NULL,
+		author_id TEXT NOT NULL,
+		boosted_by TEXT,
+		created_at TIMESTAMP NOT NULL,
+		UNIQUE(user_id, post_id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_home_timeline_user
+	ON home_timeline(user_id, created_at, id)
+`)
+	if err != nil {
+		return err
+	}
+
+	// Timelines used to be assembled on read; fill them in once
+	if !timelineExists {
+		if err = RebuildHomeTimelines(""); err != nil {
+			return err
+		}
+	}
+
+	// Create domain_blocks table (instance-level defederation)
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS domain_blocks (
+		domain TEXT PRIMARY KEY,
+		severity TEXT NOT NULL,
+		reject_media BOOLEAN NOT NULL DEFAULT FALSE,
+		reject_reports BOOLEAN NOT NULL DEFAULT FALSE,
+		public_comment TEXT,
+		private_comment TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)
+`)
+	if err != nil {
+		return err
+	}
+
+	return err
+}
+
+// addColumn adds a column to a table created by an earlier version, doing
+// nothing if the column already exists.
+func addColumn(table, column, definition string) error {
+	exists, err := hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
+
+func hasColumn(table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,294 @@
+package models
+
+import (
+	"Aervyn/internal/activitypub"
+	"Aervyn/internal/config"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+
+	maxDeliveryAttempts = 12
+	deliveryBatchSize   = 50
+	deliveryWorkers     = 4
+	deliveryInterval    = 5 * time.Second
+)
+
+// Delivery is a queued outgoing activity. Inbox is empty until the inbox of
+// Recipient has been looked up; Shared lets that be their sharedInbox.
+type Delivery struct {
+	ID            string
+	UserID        string
+	Inbox         string
+	Recipient     string
+	Shared        bool
+	Payload       string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
+
+// EnqueueDelivery stores an outgoing activity for the given inbox. The
+// delivery worker picks it up and retries it until it succeeds or dies.
+func EnqueueDelivery(userID, inbox string, activity interface{}) error {
+	payload, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	return insertDelivery(db, userID, inbox, "", false, payload)
+}
+
+// EnqueueDeliveryTo stores an outgoing activity for a remote actor whose
+// inbox is looked up by the delivery worker, so an actor whose server is
+// down right now still gets it once the server is back.
+func EnqueueDeliveryTo(userID, actor string, activity interface{}) error {
+	payload, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	return insertDelivery(db, userID, "", actor, false, payload)
+}
+
+// DeliverToFollowers queues an activity for every accepted remote follower
+// of a user. The worker looks up their inboxes, preferring each server's
+// sharedInbox, and sends every inbox a single copy.
+func DeliverToFollowers(userID string, activity interface{}) error {
+	followers, err := GetRemoteFollowers(userID)
+	if err != nil || len(followers) == 0 {
+		return err
+	}
+
+	payload, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, actor := range followers {
+		if err := insertDelivery(tx, userID, "", actor, true, payload); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// execer is a *sql.DB or a *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertDelivery(e execer, userID, inbox, recipient string, shared bool, payload []byte) error {
+	now := time.Now()
+	_, err := e.Exec(`
+        INSERT INTO deliveries
+        (id, user_id, inbox, recipient, shared, payload, status, attempts, next_attempt_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
+    `, uuid.New().String(), userID, inbox, recipient, shared, string(payload), DeliveryPending, now, now)
+	return err
+}
+
+// StartDeliveryWorker polls the deliveries table in the background. Pending
+// jobs survive restarts because they are only ever read from the database.
+func StartDeliveryWorker() {
+	go func() {
+		ticker := time.NewTicker(deliveryInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := processDueDeliveries(); err != nil {
+				log.Printf("Error processing deliveries: %v", err)
+			}
+		}
+	}()
+}
+
+func processDueDeliveries() error {
+	rows, err := db.Query(`
+        SELECT id, user_id, inbox, COALESCE(recipient, ''), shared, payload, attempts
+        FROM deliveries
+        WHERE status = ? AND next_attempt_at <= ?
+        ORDER BY next_attempt_at ASC
+        LIMIT ?
+    `, DeliveryPending, time.Now(), deliveryBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var due []Delivery
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Inbox, &d.Recipient, &d.Shared, &d.Payload, &d.Attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	jobs := make(chan Delivery)
+	var wg sync.WaitGroup
+	for i := 0; i < deliveryWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				d.attempt()
+			}
+		}()
+	}
+	for _, d := range due {
+		jobs <- d
+	}
+	close(jobs)
+	wg.Wait()
+
+	return nil
+}
+
+func (d *Delivery) attempt() {
+	if d.Inbox == "" {
+		duplicate, err := d.resolveInbox()
+		if err != nil {
+			d.fail(err, !isTransient(err))
+			return
+		}
+		if duplicate {
+			return
+		}
+	}
+
+	if err := CheckFederation(d.Inbox); err != nil {
+		d.fail(err, true)
+		return
+	}
+
+	keyID, privateKey, err := d.signingKey()
+	if err != nil {
+		// Only a sender that no longer exists is hopeless
+		d.fail(err, errors.Is(err, sql.ErrNoRows))
+		return
+	}
+
+	err = activitypub.Deliver([]byte(d.Payload), d.Inbox, keyID, privateKey)
+	if err != nil {
+		var deliveryErr *activitypub.DeliveryError
+		permanent := errors.As(err, &deliveryErr) && deliveryErr.Permanent()
+		d.fail(err, permanent)
+		return
+	}
+
+	_, err = db.Exec(`
+        UPDATE deliveries
+        SET status = ?, attempts = attempts + 1, last_error = NULL
+        WHERE id = ?
+    `, DeliveryDelivered, d.ID)
+	if err != nil {
+		log.Printf("Error marking delivery %s as delivered: %v", d.ID, err)
+	}
+}
+
+// resolveInbox looks up the inbox of the delivery's recipient and keeps it
+// for later attempts. Followers on one server often share an inbox, so a
+// delivery whose activity is already going to the same inbox is dropped and
+// reported as a duplicate.
+func (d *Delivery) resolveInbox() (bool, error) {
+	profile, err := FetchRemoteProfile(d.Recipient)
+	if err != nil {
+		return false, err
+	}
+	inbox := profile.InboxURL
+	if d.Shared && profile.SharedInbox != "" {
+		inbox = profile.SharedInbox
+	}
+	if inbox == "" {
+		return false, fmt.Errorf("%s has no inbox", d.Recipient)
+	}
+
+	result, err := db.Exec(`
+        UPDATE deliveries SET inbox = ?1
+        WHERE id = ?2 AND NOT EXISTS (
+            SELECT 1 FROM deliveries other
+            WHERE other.id != ?2 AND other.inbox = ?1 AND other.status != ?3
+            AND json_extract(other.payload, '$.id') = json_extract(deliveries.payload, '$.id')
+        )
+    `, inbox, d.ID, DeliveryDead)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		d.Inbox = inbox
+		return false, err
+	}
+
+	_, err = db.Exec("DELETE FROM deliveries WHERE id = ?", d.ID)
+	return true, err
+}
+
+// signingKey returns the key ID and private key of the delivery's sender,
+// which is a local user or the instance actor.
+func (d *Delivery) signingKey() (string, string, error) {
+	if d.UserID == InstanceActorID {
+		_, privateKey, err := GetInstanceKeys()
+		return InstanceActorURL() + "#main-key", privateKey, err
+	}
+
+	user, err := GetUserByID(d.UserID)
+	if err != nil {
+		return "", "", err
+	}
+	return config.GetActorURL(user.Username) + "#main-key", user.PrivateKey, nil
+}
+
+func (d *Delivery) fail(cause error, permanent bool) {
+	attempts := d.Attempts + 1
+	status := DeliveryPending
+	if permanent || attempts >= maxDeliveryAttempts {
+		status = DeliveryDead
+	}
+
+	to := d.Inbox
+	if to == "" {
+		to = d.Recipient
+	}
+	log.Printf("Delivery %s to %s failed (attempt %d, %s): %v", d.ID, to, attempts, status, cause)
+
+	_, err := db.Exec(`
+        UPDATE deliveries
+        SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?
+        WHERE id = ?
+    `, status, attempts, time.Now().Add(deliveryBackoff(attempts)), cause.Error(), d.ID)
+	if err != nil {
+		log.Printf("Error updating delivery %s: %v", d.ID, err)
+	}
+}
+
+// deliveryBackoff doubles the wait after every failed attempt, starting at
+// 30 seconds and capped at 12 hours.
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= 12*time.Hour {
+			return 12 * time.Hour
+		}
+	}
+	return backoff
+}
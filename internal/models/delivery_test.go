@@ -0,0 +1,117 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"Aervyn/internal/config"
+)
+
+func TestDeliverToFollowersSharesInboxes(t *testing.T) {
+	user, err := CreateUser("dl_alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bob and carol share their server's inbox, dave's server has none
+	followers := map[string]string{
+		"https://shared.example/users/bob":   "https://shared.example/inbox",
+		"https://shared.example/users/carol": "https://shared.example/inbox",
+		"https://solo.example/users/dave":    "",
+	}
+	for actor, sharedInbox := range followers {
+		err := StoreRemoteProfile(&Profile{ID: actor, InboxURL: actor + "/inbox", SharedInbox: sharedInbox})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := CreateFollowRequest(actor, user.ID, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	activity := map[string]string{"id": "https://local.example/activities/dl-1", "type": "Create"}
+	if err := DeliverToFollowers(user.ID, activity); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query(`
+        SELECT id, user_id, inbox, recipient, shared, payload
+        FROM deliveries WHERE user_id = ?
+    `, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queued []Delivery
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Inbox, &d.Recipient, &d.Shared, &d.Payload); err != nil {
+			t.Fatal(err)
+		}
+		queued = append(queued, d)
+	}
+	rows.Close()
+	if len(queued) != len(followers) {
+		t.Fatalf("queued %d deliveries, want one per follower", len(queued))
+	}
+
+	got := make(map[string]int)
+	for _, d := range queued {
+		duplicate, err := d.resolveInbox()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !duplicate {
+			got[d.Inbox]++
+		}
+	}
+
+	want := map[string]int{
+		"https://shared.example/inbox":          1,
+		"https://solo.example/users/dave/inbox": 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolved inboxes = %v, want %v", got, want)
+	}
+
+	var left int
+	db.QueryRow("SELECT COUNT(*) FROM deliveries WHERE user_id = ?", user.ID).Scan(&left)
+	if left != len(want) {
+		t.Errorf("%d deliveries left, want %d", left, len(want))
+	}
+}
+
+func TestDeliveredActivityServesOnlyPublicResponses(t *testing.T) {
+	tests := []struct {
+		id       string
+		activity string
+		object   string
+		want     bool
+	}{
+		{"dv-accept", "Accept", "Follow", true},
+		{"dv-undo-follow", "Undo", "Follow", true},
+		{"dv-undo-like", "Undo", "Like", true},
+		{"dv-undo-announce", "Undo", "Announce", true},
+		{"dv-undo-block", "Undo", "Block", false},
+		{"dv-flag", "Flag", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			payload := fmt.Sprintf(`{"id": "%s/activities/%s", "type": %q, "object": {"type": %q}}`,
+				config.InstanceURL, tt.id, tt.activity, tt.object)
+			if err := insertDelivery(db, "dv_alice", "https://remote.example/inbox", "", false, []byte(payload)); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := deliveredActivity(tt.id)
+			if tt.want && err != nil {
+				t.Errorf("deliveredActivity() error = %v", err)
+			}
+			if !tt.want && err != sql.ErrNoRows {
+				t.Errorf("deliveredActivity() error = %v, want %v", err, sql.ErrNoRows)
+			}
+		})
+	}
+}
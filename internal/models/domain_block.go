@@ -0,0 +1,361 @@
+package models
+
+import (
+	"Aervyn/internal/config"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Domain block severities, named as in the common CSV blocklist format.
+// BlockNoop blocks nothing by itself and is used for media-only blocks.
+const (
+	BlockSilence = "silence"
+	BlockSuspend = "suspend"
+	BlockNoop    = "noop"
+)
+
+var (
+	// ErrDomainBlocked is returned when federating with a suspended domain.
+	ErrDomainBlocked = errors.New("domain is blocked")
+	// ErrDomainNotAllowed is returned in allowlist mode for other domains.
+	ErrDomainNotAllowed = errors.New("domain is not on the federation allowlist")
+	// ErrActorSuspended is returned for accounts suspended by moderators.
+	ErrActorSuspended = errors.New("account is suspended")
+)
+
+type DomainBlock struct {
+	Domain         string
+	Severity       string
+	RejectMedia    bool
+	RejectReports  bool
+	PublicComment  string
+	PrivateComment string
+	CreatedAt      time.Time
+}
+
+// normalizeDomain lowercases a domain and strips any scheme or path an
+// admin may have pasted along with it.
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if u, err := url.Parse(domain); err == nil && u.Host != "" {
+		domain = u.Host
+	}
+	return strings.Trim(domain, "./")
+}
+
+// hostOf returns the host of a URL, or the input itself if it is a bare host.
+func hostOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return strings.ToLower(u.Hostname())
+	}
+	return strings.ToLower(rawURL)
+}
+
+// SameHost reports whether two URLs are on the same host.
+func SameHost(a, b string) bool {
+	host := hostOf(a)
+	return host != "" && host == hostOf(b)
+}
+
+// SaveDomainBlock creates or updates a domain block. Suspending a domain
+// also purges everything we hold from it.
+func SaveDomainBlock(block *DomainBlock) error {
+	block.Domain = normalizeDomain(block.Domain)
+	if block.Domain == "" {
+		return fmt.Errorf("domain is required")
+	}
+	switch block.Severity {
+	case BlockSilence, BlockSuspend, BlockNoop:
+	default:
+		return fmt.Errorf("unknown severity %q", block.Severity)
+	}
+
+	_, err := db.Exec(`
+        INSERT INTO domain_blocks
+        (domain, severity, reject_media, reject_reports, public_comment, private_comment, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(domain) DO UPDATE SET
+        severity = excluded.severity,
+        reject_media = excluded.reject_media,
+        reject_reports = excluded.reject_reports,
+        public_comment = excluded.public_comment,
+        private_comment = excluded.private_comment
+    `, block.Domain, block.Severity, block.RejectMedia, block.RejectReports,
+		block.PublicComment, block.PrivateComment, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if block.Severity == BlockSuspend {
+		return purgeDomain(block.Domain)
+	}
+	if block.RejectMedia {
+		_, err = db.Exec(`UPDATE actors SET icon = '' WHERE NOT local AND `+domainMatch("uri"), block.Domain)
+	}
+	return err
+}
+
+func DeleteDomainBlock(domain string) error {
+	_, err := db.Exec("DELETE FROM domain_blocks WHERE domain = ?", normalizeDomain(domain))
+	return err
+}
+
+func GetDomainBlocks() ([]DomainBlock, error) {
+	rows, err := db.Query(`
+        SELECT domain, severity, reject_media, reject_reports,
+               COALESCE(public_comment, ''), COALESCE(private_comment, ''), created_at
+        FROM domain_blocks
+        ORDER BY domain ASC
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []DomainBlock
+	for rows.Next() {
+		var b DomainBlock
+		err := rows.Scan(&b.Domain, &b.Severity, &b.RejectMedia, &b.RejectReports,
+			&b.PublicComment, &b.PrivateComment, &b.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// GetDomainBlock returns the block covering a host, either on the host
+// itself or on a parent domain. It returns sql.ErrNoRows if there is none.
+func GetDomainBlock(host string) (*DomainBlock, error) {
+	host = hostOf(host)
+
+	var b DomainBlock
+	err := db.QueryRow(`
+        SELECT domain, severity, reject_media, reject_reports,
+               COALESCE(public_comment, ''), COALESCE(private_comment, ''), created_at
+        FROM domain_blocks
+        WHERE `+hostMatch("?1", "domain")+`
+        ORDER BY CASE severity WHEN 'suspend' THEN 0 WHEN 'silence' THEN 1 ELSE 2 END
+        LIMIT 1
+    `, host).Scan(&b.Domain, &b.Severity, &b.RejectMedia, &b.RejectReports,
+		&b.PublicComment, &b.PrivateComment, &b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// CheckFederation returns ErrDomainBlocked or ErrDomainNotAllowed if we
+// must not exchange activities with the server behind rawURL, which may
+// also be a bare host, and ErrActorSuspended if rawURL is a suspended actor.
+func CheckFederation(rawURL string) error {
+	if IsSuspended(rawURL) {
+		return fmt.Errorf("%w: %s", ErrActorSuspended, rawURL)
+	}
+	if config.AllowlistMode && !domainAllowed(hostOf(rawURL)) {
+		return fmt.Errorf("%w: %s", ErrDomainNotAllowed, hostOf(rawURL))
+	}
+
+	block, err := GetDomainBlock(rawURL)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if block.Severity == BlockSuspend {
+		return fmt.Errorf("%w: %s", ErrDomainBlocked, hostOf(rawURL))
+	}
+	return nil
+}
+
+// domainAllowed reports whether host is on the allowlist, directly or as a
+// subdomain of an allowed domain.
+func domainAllowed(host string) bool {
+	for _, domain := range config.AllowedDomains {
+		domain = normalizeDomain(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSilenced reports whether posts from the actor are kept out of timelines,
+// because of its domain or because moderators acted on the account.
+func IsSilenced(actor string) bool {
+	if actorModeration(actor) != "" {
+		return true
+	}
+	block, err := GetDomainBlock(actor)
+	return err == nil && block.Severity == BlockSilence
+}
+
+// silencedActorIDs selects the IDs of the remote actors on silenced
+// domains that user ?1 doesn't follow.
+var silencedActorIDs = `
+            SELECT a.id FROM actors a
+            JOIN domain_blocks d ON d.severity = 'silence'
+            AND ` + hostMatch(uriHost("a.uri"), "d.domain") + `
+            WHERE NOT a.local AND a.id NOT IN (` + followedActorIDs + `)`
+
+// rejectsMedia reports whether media from the actor's server is dropped.
+func rejectsMedia(actor string) bool {
+	block, err := GetDomainBlock(actor)
+	return err == nil && block.RejectMedia
+}
+
+// domainMatch is a WHERE clause matching URIs in column on the domain
+// bound to ?1 or any of its subdomains.
+func domainMatch(column string) string {
+	return hostMatch(uriHost(column), "?1")
+}
+
+// uriHost is an SQL expression for the lowercased host of the URI in
+// column, without its port.
+func uriHost(column string) string {
+	rest := fmt.Sprintf("substr(%[1]s, instr(%[1]s, '://') + 3)", column)
+	authority := fmt.Sprintf("substr(%[1]s, 1, instr(%[1]s || '/', '/') - 1)", rest)
+	return fmt.Sprintf("lower(substr(%[1]s, 1, instr(%[1]s || ':', ':') - 1))", authority)
+}
+
+// hostMatch is an SQL condition on two SQL expressions that holds when host
+// is domain or ends in "." followed by domain. It compares exactly, so "_"
+// and "%" in either are not wildcards.
+func hostMatch(host, domain string) string {
+	return fmt.Sprintf("(substr('.' || %s, -length(%s) - 1) = '.' || %s)", host, domain, domain)
+}
+
+// purgeDomain drops follows in both directions and everything cached from
+// a suspended domain.
+func purgeDomain(domain string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	actors := remoteActorIDs(domainMatch("uri"))
+	statements := []string{
+		"DELETE FROM followers WHERE user_id IN " + actors + " OR actor IN " + actors,
+		"DELETE FROM likes WHERE user_id IN " + actors + " OR " + domainMatch("post_id"),
+		"DELETE FROM boosts WHERE user_id IN " + actors + " OR " + domainMatch("post_id"),
+		"DELETE FROM remote_posts WHERE author IN " + actors + " OR " + domainMatch("id"),
+		"DELETE FROM outbox_polls WHERE actor_id IN " + actors,
+		"DELETE FROM home_timeline WHERE author_id IN " + actors + " OR boosted_by IN " + actors,
+		"DELETE FROM actors WHERE NOT local AND " + domainMatch("uri") + " AND id NOT IN " + moderatedActors,
+		"DELETE FROM deliveries WHERE status = 'pending' AND (" + domainMatch("inbox") + " OR " + domainMatch("recipient") + ")",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, domain); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Purged suspended domain %s", domain)
+	return tx.Commit()
+}
+
+// domainBlockCSVHeader is the header of the common blocklist format used
+// by Mastodon and others.
+var domainBlockCSVHeader = []string{
+	"#domain", "#severity", "#reject_media", "#reject_reports", "#public_comment", "#obfuscate",
+}
+
+// ExportDomainBlocks writes all domain blocks as a blocklist CSV. Private
+// comments are not exported.
+func ExportDomainBlocks(w io.Writer) error {
+	blocks, err := GetDomainBlocks()
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(domainBlockCSVHeader); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		record := []string{
+			b.Domain,
+			b.Severity,
+			strconv.FormatBool(b.RejectMedia),
+			strconv.FormatBool(b.RejectReports),
+			b.PublicComment,
+			"false",
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ImportDomainBlocks reads a blocklist CSV and saves every entry. Columns
+// are matched by header name with or without the leading '#'; a file
+// without a header is read as one domain per line, each suspended.
+func ImportDomainBlocks(r io.Reader) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	columns := map[string]int{"domain": 0}
+	if strings.HasPrefix(records[0][0], "#") || strings.EqualFold(records[0][0], "domain") {
+		columns = make(map[string]int)
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))] = i
+		}
+		records = records[1:]
+	}
+	if _, ok := columns["domain"]; !ok {
+		return 0, fmt.Errorf("blocklist has no domain column")
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	imported := 0
+	for _, record := range records {
+		block := &DomainBlock{
+			Domain:        field(record, "domain"),
+			Severity:      strings.ToLower(field(record, "severity")),
+			PublicComment: field(record, "public_comment"),
+		}
+		if block.Domain == "" {
+			continue
+		}
+		if block.Severity == "" {
+			block.Severity = BlockSuspend
+		}
+		block.RejectMedia, _ = strconv.ParseBool(field(record, "reject_media"))
+		block.RejectReports, _ = strconv.ParseBool(field(record, "reject_reports"))
+
+		if err := SaveDomainBlock(block); err != nil {
+			return imported, fmt.Errorf("%s: %w", block.Domain, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
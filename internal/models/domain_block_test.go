@@ -0,0 +1,36 @@
+package models
+
+import "testing"
+
+func TestDomainMatch(t *testing.T) {
+	tests := []struct {
+		domain string
+		uri    string
+		want   bool
+	}{
+		{"bad.example", "https://bad.example/users/alice", true},
+		{"bad.example", "https://sub.bad.example/users/alice", true},
+		{"bad.example", "https://bad.example:8443/users/alice", true},
+		{"bad.example", "https://BAD.example/users/alice", true},
+		{"bad.example", "https://bad.example", true},
+		{"bad.example", "https://good.example/users/alice", false},
+		{"bad.example", "https://notbad.example/users/alice", false},
+		{"bad.example", "https://good.example/x.bad.example/notes/1", false},
+		{"bad.example", "https://good.example/redirect?to=https://bad.example/", false},
+		{"bad_example", "https://badxexample/users/alice", false},
+		{"bad%example", "https://bad.other.example/users/alice", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain+" "+tt.uri, func(t *testing.T) {
+			var got bool
+			err := db.QueryRow("SELECT "+domainMatch("?2"), tt.domain, tt.uri).Scan(&got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("domainMatch(%q, %q) = %v, want %v", tt.domain, tt.uri, got, tt.want)
+			}
+		})
+	}
+}
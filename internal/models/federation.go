@@ -0,0 +1,442 @@
+package models
+
+import (
+	"Aervyn/internal/activitypub"
+	"Aervyn/internal/config"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// localActorURL returns the ActivityPub ID of a local user.
+func localActorURL(userID string) (string, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	return config.GetActorURL(user.Username), nil
+}
+
+// followActivity rebuilds the Follow we sent for a follow row. Its ID is
+// derived from the row so Accept, Reject and Undo can refer back to it.
+func followActivity(f *Follower, actorURL string) activitypub.Activity {
+	activity := activitypub.NewActivity("Follow", actorURL, f.Actor)
+	activity.ID = fmt.Sprintf("%s/activities/%s", config.InstanceURL, f.ID)
+	activity.Published = f.CreatedAt
+	return activity
+}
+
+// SendFollow delivers a Follow for a pending follow row to the target.
+func SendFollow(f *Follower) error {
+	actorURL, err := localActorURL(f.UserID)
+	if err != nil {
+		return err
+	}
+
+	return EnqueueDeliveryTo(f.UserID, f.Actor, followActivity(f, actorURL))
+}
+
+// SendUndoFollow tells the target we no longer follow them.
+func SendUndoFollow(f *Follower) error {
+	actorURL, err := localActorURL(f.UserID)
+	if err != nil {
+		return err
+	}
+
+	undo := activitypub.NewActivity("Undo", actorURL, followActivity(f, actorURL))
+	return EnqueueDeliveryTo(f.UserID, f.Actor, undo)
+}
+
+// followResponse builds an Accept or Reject of an inbound follow, quoting
+// the requester's original Follow.
+func followResponse(activityType string, f *Follower) (activitypub.Activity, error) {
+	actorURL, err := localActorURL(f.Actor)
+	if err != nil {
+		return activitypub.Activity{}, err
+	}
+
+	follow := activitypub.NewActivity("Follow", f.UserID, actorURL)
+	if f.ActivityID != "" {
+		follow.ID = f.ActivityID
+	}
+	follow.Published = f.CreatedAt
+
+	response := activitypub.NewActivity(activityType, actorURL, follow)
+	response.To = []string{f.UserID}
+	return response, nil
+}
+
+// SendFollowResponse tells a remote follower whether their follow of a
+// local user was accepted.
+func SendFollowResponse(f *Follower, accepted bool) error {
+	activityType := "Reject"
+	if accepted {
+		activityType = "Accept"
+	}
+
+	response, err := followResponse(activityType, f)
+	if err != nil {
+		return err
+	}
+	return EnqueueDeliveryTo(f.Actor, f.UserID, response)
+}
+
+// blockActivity rebuilds the Block for a block row, addressed only to the
+// blocked actor.
+func blockActivity(b *Block, actorURL string) activitypub.Activity {
+	activity := activitypub.NewActivity("Block", actorURL, b.Actor)
+	activity.ID = fmt.Sprintf("%s/activities/%s", config.InstanceURL, b.ID)
+	activity.Published = b.CreatedAt
+	activity.To = []string{b.Actor}
+	return activity
+}
+
+// SendBlock tells a remote actor we blocked them, so their server can stop
+// showing them our content.
+func SendBlock(b *Block) error {
+	actorURL, err := localActorURL(b.UserID)
+	if err != nil {
+		return err
+	}
+
+	return EnqueueDeliveryTo(b.UserID, b.Actor, blockActivity(b, actorURL))
+}
+
+// SendUndoBlock retracts a Block sent earlier.
+func SendUndoBlock(b *Block) error {
+	actorURL, err := localActorURL(b.UserID)
+	if err != nil {
+		return err
+	}
+
+	undo := activitypub.NewActivity("Undo", actorURL, blockActivity(b, actorURL))
+	undo.To = []string{b.Actor}
+	return EnqueueDeliveryTo(b.UserID, b.Actor, undo)
+}
+
+// PostNote builds the Note for a local post, addressed publicly with a copy
+// to the author's followers and, for replies, to the parent's author.
+func PostNote(p *Post, username string) activitypub.Note {
+	actorURL := config.GetActorURL(username)
+
+	note := activitypub.Note{
+		Type:         "Note",
+		ID:           activitypub.NoteID(p.ID),
+		Content:      p.Content,
+		Published:    p.CreatedAt,
+		AttributedTo: actorURL,
+		URL:          activitypub.NoteID(p.ID),
+		To:           []string{activitypub.PublicAddress},
+		Cc:           []string{actorURL + "/followers"},
+	}
+
+	if p.ReplyTo != nil {
+		inReplyTo := *p.ReplyTo
+		if !strings.HasPrefix(inReplyTo, "http") {
+			inReplyTo = activitypub.NoteID(inReplyTo)
+			if parent, err := GetPost(*p.ReplyTo); err == nil {
+				if author, err := GetUserByID(parent.UserID); err == nil {
+					note.Cc = append(note.Cc, config.GetActorURL(author.Username))
+				}
+			}
+		}
+		note.InReplyTo = &inReplyTo
+	}
+
+	return note
+}
+
+// PostCreateActivity returns the Create activity for a local post, as served
+// in the outbox and delivered to followers.
+func PostCreateActivity(p *Post, username string) activitypub.Activity {
+	return activitypub.NewCreateActivity(p.ID, PostNote(p, username))
+}
+
+// FederatePost delivers a new local post to every accepted remote follower
+// of its author. Followers on the same server share one delivery when their
+// server advertises a sharedInbox.
+func FederatePost(p *Post) error {
+	user, err := GetUserByID(p.UserID)
+	if err != nil {
+		return err
+	}
+
+	return DeliverToFollowers(p.UserID, PostCreateActivity(p, user.Username))
+}
+
+// SendDelete tells remote followers that a local post was deleted.
+func SendDelete(p *Post) error {
+	actorURL, err := localActorURL(p.UserID)
+	if err != nil {
+		return err
+	}
+
+	tombstone := activitypub.Tombstone{
+		Type: "Tombstone",
+		ID:   activitypub.NoteID(p.ID),
+	}
+	activity := activitypub.NewActivity("Delete", actorURL, tombstone)
+	activity.To = []string{activitypub.PublicAddress}
+	return DeliverToFollowers(p.UserID, activity)
+}
+
+// SendFlag forwards a report to the server of the reported actor. It is
+// sent by the instance actor so the reporter stays anonymous.
+func SendFlag(report *Report, postIDs []string) error {
+	objects := []string{report.Target}
+	for _, id := range postIDs {
+		objects = append(objects, postObjectID(id))
+	}
+
+	flag := activitypub.NewActivity("Flag", InstanceActorURL(), objects)
+	flag.Content = report.Comment
+	flag.To = []string{report.Target}
+	return EnqueueDeliveryTo(InstanceActorID, report.Target, flag)
+}
+
+// postObjectID returns the ActivityPub ID of a post, which is the post ID
+// itself for remote posts.
+func postObjectID(postID string) string {
+	if strings.HasPrefix(postID, "http") {
+		return postID
+	}
+	return activitypub.NoteID(postID)
+}
+
+// interactionActivity builds a Like or Announce whose ID is derived from the
+// row in the likes or boosts table.
+func interactionActivity(activityType, id, actorURL, postID, authorURL string) activitypub.Activity {
+	activity := activitypub.NewActivity(activityType, actorURL, postObjectID(postID))
+	activity.ID = fmt.Sprintf("%s/activities/%s", config.InstanceURL, id)
+
+	var author []string
+	if authorURL != "" {
+		author = []string{authorURL}
+	}
+
+	activity.To = author
+	if activityType == "Announce" {
+		activity.To = []string{activitypub.PublicAddress}
+		activity.Cc = append(author, actorURL+"/followers")
+	}
+	return activity
+}
+
+// deliverInteraction queues a Like or Announce, or its Undo, for the post's
+// remote author and, for Announces, our own remote followers. The author's
+// inbox is looked up by the delivery worker.
+func deliverInteraction(activityType, userID, postID, author string, activity activitypub.Activity) error {
+	if strings.HasPrefix(postID, "http") {
+		if err := EnqueueDeliveryTo(userID, author, activity); err != nil {
+			return err
+		}
+	}
+	if activityType != "Announce" {
+		return nil
+	}
+
+	return DeliverToFollowers(userID, activity)
+}
+
+// interactionAuthor returns the author of a post someone interacted with.
+// Posts can only be liked or boosted once they are stored here, so this
+// never has to fetch anything.
+func interactionAuthor(postID string) (string, error) {
+	author := storedPostAuthor(postID)
+	if author == "" {
+		return "", fmt.Errorf("author of %s is unknown", postID)
+	}
+	return author, nil
+}
+
+// SendInteraction federates a Like or Announce identified by its row ID.
+func SendInteraction(activityType, id, postID, userID string) error {
+	actorURL, err := localActorURL(userID)
+	if err != nil {
+		return err
+	}
+
+	author, err := interactionAuthor(postID)
+	if err != nil {
+		return err
+	}
+
+	activity := interactionActivity(activityType, id, actorURL, postID, author)
+	return deliverInteraction(activityType, userID, postID, author, activity)
+}
+
+// SendUndoInteraction federates Undo{Like} or Undo{Announce} for a like or
+// boost that has been removed locally, reusing the original activity ID.
+func SendUndoInteraction(activityType, id, postID, userID string) error {
+	actorURL, err := localActorURL(userID)
+	if err != nil {
+		return err
+	}
+
+	author, err := interactionAuthor(postID)
+	if err != nil {
+		return err
+	}
+
+	original := interactionActivity(activityType, id, actorURL, postID, author)
+	undo := activitypub.NewActivity("Undo", actorURL, original)
+	undo.To = original.To
+	undo.Cc = original.Cc
+	return deliverInteraction(activityType, userID, postID, author, undo)
+}
+
+// storedPostAuthor returns the author URI of a local or cached remote post
+// without touching the network, or "" when the author is unknown.
+func storedPostAuthor(postID string) string {
+	if !strings.HasPrefix(postID, "http") {
+		post, err := GetPost(postID)
+		if err != nil {
+			return ""
+		}
+		actorURL, err := localActorURL(post.UserID)
+		if err != nil {
+			return ""
+		}
+		return actorURL
+	}
+
+	var author string
+	db.QueryRow("SELECT "+actorRef("author")+" FROM remote_posts WHERE id = ?", postID).Scan(&author)
+	return author
+}
+
+// AnnounceActivity returns the Announce for one of a user's boosts, as
+// served in the outbox.
+func AnnounceActivity(boostID, postID, username string) activitypub.Activity {
+	actorURL := config.GetActorURL(username)
+	return interactionActivity("Announce", boostID, actorURL, postID, storedPostAuthor(postID))
+}
+
+// PostNoteWithCollections returns the Note for a local post as served at
+// its ID, with its replies listed and its likes and shares counted. The
+// collections are embedded without IDs, as they aren't served on their own.
+func PostNoteWithCollections(p *Post, username string) (activitypub.Note, error) {
+	note := PostNote(p, username)
+
+	replies, err := replyObjectIDs(p.ID)
+	if err != nil {
+		return note, err
+	}
+
+	note.Replies = &activitypub.OrderedCollection{
+		Type:         "OrderedCollection",
+		TotalItems:   len(replies),
+		OrderedItems: replies,
+	}
+	note.Likes = &activitypub.OrderedCollection{
+		Type:       "OrderedCollection",
+		TotalItems: p.LikeCount,
+	}
+	note.Shares = &activitypub.OrderedCollection{
+		Type:       "OrderedCollection",
+		TotalItems: p.BoostCount,
+	}
+
+	return note, nil
+}
+
+// replyObjectIDs lists the object IDs of local and cached public or unlisted
+// remote replies.
+func replyObjectIDs(postID string) ([]string, error) {
+	rows, err := db.Query(`
+        SELECT id, 1 FROM posts WHERE reply_to = ?
+        UNION ALL
+        SELECT id, 0 FROM remote_posts
+        WHERE in_reply_to = ? AND visibility IN ('public', 'unlisted') AND deleted_at IS NULL
+    `, postID, activitypub.NoteID(postID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		var local bool
+		if err := rows.Scan(&id, &local); err != nil {
+			return nil, err
+		}
+		if local {
+			id = activitypub.NoteID(id)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetLocalActivity rebuilds an activity published by a local user from the
+// ID under /activities, or finds it among the deliveries if it can't be
+// rebuilt. It returns sql.ErrNoRows for unknown IDs.
+func GetLocalActivity(id string) (interface{}, error) {
+	if post, err := GetPost(id); err == nil {
+		user, err := GetUserByID(post.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return PostCreateActivity(post, user.Username), nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	for table, activityType := range map[string]string{"boosts": "Announce", "likes": "Like"} {
+		var postID, username string
+		err := db.QueryRow(`
+            SELECT t.post_id, u.username
+            FROM `+table+` t
+            JOIN users u ON u.id = t.user_id
+            WHERE t.id = ?
+        `, id).Scan(&postID, &username)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		actorURL := config.GetActorURL(username)
+		return interactionActivity(activityType, id, actorURL, postID, storedPostAuthor(postID)), nil
+	}
+
+	var follow Follower
+	var username string
+	err := db.QueryRow(`
+        SELECT f.id, f.user_id, a.uri, f.accepted, f.created_at, u.username
+        FROM followers f
+        JOIN users u ON u.id = f.user_id
+        JOIN actors a ON a.id = f.actor AND NOT a.local
+        WHERE f.id = ?
+    `, id).Scan(&follow.ID, &follow.UserID, &follow.Actor, &follow.Accepted, &follow.CreatedAt, &username)
+	if err == sql.ErrNoRows {
+		return deliveredActivity(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return followActivity(&follow, config.GetActorURL(username)), nil
+}
+
+// deliveredActivity returns an Accept, Reject or Undo of a follow, like or
+// boost as it was delivered. These aren't backed by rows of their own.
+// Flags and Undo{Block} are left out since they are only meant for the
+// server they were sent to.
+func deliveredActivity(id string) (interface{}, error) {
+	var payload string
+	err := db.QueryRow(`
+        SELECT payload FROM deliveries
+        WHERE json_extract(payload, '$.id') = ?
+        AND (json_extract(payload, '$.type') IN ('Accept', 'Reject')
+             OR (json_extract(payload, '$.type') = 'Undo'
+                 AND json_extract(payload, '$.object.type') IN ('Follow', 'Like', 'Announce')))
+        LIMIT 1
+    `, fmt.Sprintf("%s/activities/%s", config.InstanceURL, id)).Scan(&payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(payload), nil
+}
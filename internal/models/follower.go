@@ -1,37 +1,125 @@
 package models
 
 import (
+	"database/sql"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Follower is a follow row read as "UserID follows Actor". Both are actor
+// refs: a local user ID or a remote actor URI.
 type Follower struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"userId"`
 	Actor     string    `json:"actor"`
 	Accepted  bool      `json:"accepted"`
 	CreatedAt time.Time `json:"createdAt"`
+
+	// ActivityID is the ID of the remote Follow behind an inbound follow
+	ActivityID string `json:"-"`
 }
 
-func CreateFollowRequest(userID, actor string) error {
-	followID := uuid.New().String()
-	_, err := db.Exec(`
-        INSERT INTO followers (id, user_id, actor, accepted, created_at)
-        VALUES (?, ?, ?, ?, ?)
+// CreateFollowRequest records that userID follows the local user actor.
+// The follow is accepted right away unless actor's account is locked. A
+// repeated request keeps an earlier approval and refreshes activityID,
+// which is empty for follows between local users.
+func CreateFollowRequest(userID, actor, activityID string) (*Follower, error) {
+	followerID, err := actorID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := !IsLocked(actor)
+	_, err = db.Exec(`
+        INSERT INTO followers (id, user_id, actor, accepted, created_at, activity_id)
+        VALUES (?, ?, ?, ?, ?, NULLIF(?, ''))
         ON CONFLICT(user_id, actor) DO UPDATE SET
-        accepted = FALSE
-    `, followID, userID, actor, true, time.Now())
+        accepted = followers.accepted OR excluded.accepted,
+        activity_id = COALESCE(excluded.activity_id, followers.activity_id)
+    `, uuid.New().String(), followerID, actor, accepted, time.Now(), activityID)
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	f, err := GetFollow(userID, actor)
+	if err != nil {
+		return nil, err
+	}
+	if f.Accepted {
+		if err := mergeFollow(f.ID); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
 }
 
+// IsLocked reports whether a local user approves followers by hand.
+func IsLocked(userID string) bool {
+	var locked bool
+	db.QueryRow("SELECT locked FROM users WHERE id = ?", userID).Scan(&locked)
+	return locked
+}
+
+// CreatePendingFollow records an outgoing follow that waits for the remote
+// server to Accept it. An existing row is returned unchanged.
+func CreatePendingFollow(userID, actor string) (*Follower, error) {
+	followedID, err := actorID(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+        INSERT INTO followers (id, user_id, actor, accepted, created_at)
+        VALUES (?, ?, ?, FALSE, ?)
+        ON CONFLICT(user_id, actor) DO NOTHING
+    `, uuid.New().String(), userID, followedID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return GetFollow(userID, actor)
+}
+
+// followColumns selects a follow row aliased f with both sides as refs.
+var followColumns = `f.id, ` + actorRef("f.user_id") + `, ` + actorRef("f.actor") + `,
+        f.accepted, f.created_at, COALESCE(f.activity_id, '')`
+
+func GetFollow(userID, actor string) (*Follower, error) {
+	return scanFollow(db.QueryRow(`
+        SELECT `+followColumns+`
+        FROM followers f
+        WHERE f.user_id = ? AND f.actor = ?
+    `, findActorID(userID), findActorID(actor)))
+}
+
+// GetFollowByID returns a follow row by its own ID.
+func GetFollowByID(id string) (*Follower, error) {
+	return scanFollow(db.QueryRow(`
+        SELECT `+followColumns+`
+        FROM followers f
+        WHERE f.id = ?
+    `, id))
+}
+
+func scanFollow(row *sql.Row) (*Follower, error) {
+	var f Follower
+	err := row.Scan(&f.ID, &f.UserID, &f.Actor, &f.Accepted, &f.CreatedAt, &f.ActivityID)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// GetFollowRequests returns the pending follows of a local user that are
+// waiting for them to approve, newest first.
 func GetFollowRequests(userID string) ([]Follower, error) {
 	rows, err := db.Query(`
-        SELECT id, user_id, actor, accepted, created_at
-        FROM followers
-        WHERE user_id = ? AND accepted = FALSE
-        ORDER BY created_at DESC
+        SELECT `+followColumns+`
+        FROM followers f
+        WHERE f.actor = ? AND f.accepted = FALSE
+        ORDER BY f.created_at DESC
     `, userID)
 	if err != nil {
 		return nil, err
@@ -41,13 +129,27 @@ func GetFollowRequests(userID string) ([]Follower, error) {
 	var followers []Follower
 	for rows.Next() {
 		var f Follower
-		err := rows.Scan(&f.ID, &f.UserID, &f.Actor, &f.Accepted, &f.CreatedAt)
+		err := rows.Scan(&f.ID, &f.UserID, &f.Actor, &f.Accepted, &f.CreatedAt, &f.ActivityID)
 		if err != nil {
 			return nil, err
 		}
 		followers = append(followers, f)
 	}
-	return followers, nil
+	return followers, rows.Err()
+}
+
+// CountFollowRequestNotifications counts the pending follows a user is
+// notified about: all of them except those from actors they have muted with
+// notifications hidden.
+func CountFollowRequestNotifications(userID string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+        SELECT COUNT(*)
+        FROM followers f
+        WHERE f.actor = ?1 AND f.accepted = FALSE
+        AND f.user_id NOT IN (`+notificationMutes+`)
+    `, userID, time.Now()).Scan(&count)
+	return count, err
 }
 
 func AcceptFollowRequest(id string) error {
@@ -56,7 +158,10 @@ func AcceptFollowRequest(id string) error {
         SET accepted = TRUE
         WHERE id = ?
     `, id)
-	return err
+	if err != nil {
+		return err
+	}
+	return mergeFollow(id)
 }
 
 func RejectFollowRequest(id string) error {
@@ -67,6 +172,75 @@ func RejectFollowRequest(id string) error {
 	return err
 }
 
+// GetRemoteFollowers returns the actor URIs of remote accounts whose
+// follow of the local user has been accepted.
+func GetRemoteFollowers(userID string) ([]string, error) {
+	rows, err := db.Query(`
+        SELECT a.uri
+        FROM followers f
+        JOIN actors a ON a.id = f.user_id
+        WHERE f.actor = ?
+        AND f.accepted = true
+        AND NOT a.local
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actors []string
+	for rows.Next() {
+		var actor string
+		if err := rows.Scan(&actor); err != nil {
+			return nil, err
+		}
+		actors = append(actors, actor)
+	}
+	return actors, rows.Err()
+}
+
+// GetFollowerURIs returns a page of actor URIs following the user, newest first.
+func GetFollowerURIs(userID string, limit, offset int) ([]string, error) {
+	return getFollowURIs(`
+        SELECT a.uri
+        FROM followers f
+        JOIN actors a ON a.id = f.user_id
+        WHERE f.actor = ? AND f.accepted = true
+        ORDER BY f.created_at DESC
+        LIMIT ? OFFSET ?
+    `, userID, limit, offset)
+}
+
+// GetFollowingURIs returns a page of actor URIs the user follows, newest first.
+func GetFollowingURIs(userID string, limit, offset int) ([]string, error) {
+	return getFollowURIs(`
+        SELECT a.uri
+        FROM followers f
+        JOIN actors a ON a.id = f.actor
+        WHERE f.user_id = ? AND f.accepted = true
+        ORDER BY f.created_at DESC
+        LIMIT ? OFFSET ?
+    `, userID, limit, offset)
+}
+
+func getFollowURIs(query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uris := make([]string, 0)
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return nil, err
+		}
+		uris = append(uris, uri)
+	}
+	return uris, rows.Err()
+}
+
 // Check if user is following another user
 func IsFollowing(userID, actor string) (bool, error) {
 	var exists bool
@@ -77,7 +251,7 @@ func IsFollowing(userID, actor string) (bool, error) {
             AND actor = ? 
             AND accepted = true
         )
-    `, userID, actor).Scan(&exists)
+    `, findActorID(userID), findActorID(actor)).Scan(&exists)
 	return exists, err
 }
 
@@ -89,7 +263,7 @@ func GetFollowerCount(actor string) (int, error) {
         FROM followers 
         WHERE actor = ? 
         AND accepted = true
-    `, actor).Scan(&count)
+    `, findActorID(actor)).Scan(&count)
 	return count, err
 }
 
@@ -101,15 +275,90 @@ func GetFollowingCount(userID string) (int, error) {
         FROM followers 
         WHERE user_id = ? 
         AND accepted = true
-    `, userID).Scan(&count)
+    `, findActorID(userID)).Scan(&count)
 	return count, err
 }
 
 // Unfollow a user
 func Unfollow(userID, actor string) error {
+	followerID, followedID := findActorID(userID), findActorID(actor)
 	_, err := db.Exec(`
         DELETE FROM followers
         WHERE user_id = ? AND actor = ?
-    `, userID, actor)
-	return err
+    `, followerID, followedID)
+	if err != nil {
+		return err
+	}
+	return unmergeFollow(followerID, followedID)
+}
+
+// migrateInboundFollows turns around the follows of local users by remote
+// actors, which used to be stored as if the local user followed the remote
+// actor. That made them indistinguishable from outgoing follows, so nobody
+// could tell who a local user's followers were. The Follow activities in
+// the inbox say which rows they created: a row no older than the Follow is
+// replaced by the reverse follow, while an older row was a real outgoing
+// follow and stays, with the reverse follow added next to it.
+func migrateInboundFollows() error {
+	rows, err := db.Query(`
+        SELECT f.id, f.user_id, f.actor, f.created_at, ia.id, ia.created_at
+        FROM inbox_activities ia
+        JOIN followers f ON f.user_id = ia.user_id AND f.actor = ia.actor
+        WHERE ia.activity_type = 'Follow'
+        ORDER BY ia.created_at ASC
+    `)
+	if err != nil {
+		return err
+	}
+
+	type inbound struct {
+		followID, userID, actor, activityID string
+		followedAt, requestedAt             time.Time
+	}
+	var follows []inbound
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var f inbound
+		if err := rows.Scan(&f.followID, &f.userID, &f.actor, &f.followedAt, &f.activityID, &f.requestedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		// The earliest Follow is the one that may have created the row
+		if !seen[f.followID] {
+			seen[f.followID] = true
+			follows = append(follows, f)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, f := range follows {
+		_, err := tx.Exec(`
+            INSERT INTO followers (id, user_id, actor, accepted, created_at, activity_id)
+            VALUES (?, ?, ?, TRUE, ?, ?)
+            ON CONFLICT(user_id, actor) DO NOTHING
+        `, uuid.New().String(), f.actor, f.userID, f.requestedAt, f.activityID)
+		if err != nil {
+			return err
+		}
+		if f.followedAt.Before(f.requestedAt) {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM followers WHERE id = ?", f.followID); err != nil {
+			return err
+		}
+	}
+
+	if len(follows) > 0 {
+		log.Printf("Turned around %d inbound follows", len(follows))
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,370 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// Each user's following timeline is materialized in home_timeline: posts
+// and boosts are added to the timelines of the author's or booster's local
+// followers as they arrive, and read back newest first. Entries are ordered
+// by the post's (or boost's) time in UTC, so backfilled posts slot in where
+// they belong; the entry ID breaks ties and serves as the page cursor.
+
+// postAuthorID is an SQL expression for the actor ID of the author of the
+// post in column, local or remote. Remote posts that aren't public or
+// unlisted have no author here, so their boosts reach no one.
+func postAuthorID(column string) string {
+	return `COALESCE(
+            (SELECT user_id FROM posts WHERE id = ` + column + `),
+            (SELECT author FROM remote_posts WHERE id = ` + column + `
+             AND visibility IN ('public', 'unlisted') AND deleted_at IS NULL))`
+}
+
+// timelineTime is the time a post is sorted by. Remote servers may claim
+// times in the future.
+func timelineTime(t time.Time) time.Time {
+	if now := time.Now(); t.After(now) {
+		t = now
+	}
+	return t.UTC()
+}
+
+// addToHomeTimelines puts a post on the home timelines of the local users
+// following its author and of the author itself, or, for a boost, of the
+// local users following the booster.
+func addToHomeTimelines(postID, authorID, boostedBy string, at time.Time) error {
+	actor := authorID
+	if boostedBy != "" {
+		actor = boostedBy
+	}
+
+	_, err := db.Exec(`
+        INSERT INTO home_timeline (user_id, post_id, author_id, boosted_by, created_at)
+        SELECT f.user_id, ?1, ?2, NULLIF(?3, ''), ?4
+        FROM followers f
+        JOIN users u ON u.id = f.user_id
+        WHERE f.actor = ?5 AND f.accepted = TRUE
+        UNION
+        SELECT id, ?1, ?2, NULL, ?4 FROM users WHERE id = ?2 AND ?3 = ''
+        ON CONFLICT(user_id, post_id) DO NOTHING
+    `, postID, authorID, boostedBy, timelineTime(at), actor)
+	return err
+}
+
+// fanOut is addToHomeTimelines for callers that carry on regardless.
+func fanOut(postID, authorID, boostedBy string, at time.Time) {
+	if err := addToHomeTimelines(postID, authorID, boostedBy, at); err != nil {
+		log.Printf("Error adding %s to home timelines: %v", postID, err)
+	}
+}
+
+// addNoteToHomeTimelines puts a remote note on the timelines of the local
+// users it was addressed to: the author's followers unless it is a direct
+// message, and anyone it names in to or cc.
+func addNoteToHomeTimelines(note *RemoteNote, authorID, visibility string, at time.Time) error {
+	if visibility != visibilityDirect {
+		if err := addToHomeTimelines(note.ID, authorID, "", at); err != nil {
+			return err
+		}
+	}
+
+	for _, address := range append(append([]string{}, note.To...), note.Cc...) {
+		userID, ok := localUserID(address)
+		if !ok {
+			continue
+		}
+		_, err := db.Exec(`
+            INSERT INTO home_timeline (user_id, post_id, author_id, created_at)
+            VALUES (?, ?, ?, ?)
+            ON CONFLICT(user_id, post_id) DO NOTHING
+        `, userID, note.ID, authorID, timelineTime(at))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeBoostFromHomeTimelines takes a withdrawn boost back out of the
+// timelines it was added to.
+func removeBoostFromHomeTimelines(postID, boostedBy string) error {
+	_, err := db.Exec(
+		"DELETE FROM home_timeline WHERE post_id = ? AND boosted_by = ?",
+		postID, boostedBy,
+	)
+	return err
+}
+
+// fillHomeTimeline adds the posts and boosts of the actors selected by
+// actors, an SQL subquery of actor IDs over args, to a user's timeline.
+func fillHomeTimeline(userID, actors string, args ...interface{}) error {
+	rows, err := db.Query(`
+        SELECT id, user_id, '', created_at
+        FROM posts
+        WHERE user_id IN `+actors+`
+        UNION ALL
+        SELECT id, author, '', published
+        FROM remote_posts
+        WHERE author IN `+actors+` AND visibility != 'direct' AND deleted_at IS NULL
+        UNION ALL
+        SELECT b.post_id, `+postAuthorID("b.post_id")+`, b.user_id, b.created_at
+        FROM boosts b
+        WHERE b.user_id IN `+actors+`
+    `, args...)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		postID, authorID, boostedBy string
+		at                          time.Time
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		var authorID sql.NullString
+		if err := rows.Scan(&e.postID, &authorID, &e.boostedBy, &e.at); err != nil {
+			rows.Close()
+			return err
+		}
+		// Boosts of posts we don't have can't be shown
+		if !authorID.Valid {
+			continue
+		}
+		e.authorID = authorID.String
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Posts come before boosts, so a post that is both shows as a post
+	for _, e := range entries {
+		_, err := tx.Exec(`
+            INSERT INTO home_timeline (user_id, post_id, author_id, boosted_by, created_at)
+            VALUES (?, ?, ?, NULLIF(?, ''), ?)
+            ON CONFLICT(user_id, post_id) DO NOTHING
+        `, userID, e.postID, e.authorID, e.boostedBy, timelineTime(e.at))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// mergeFollow adds what a newly followed actor has posted and boosted to
+// the follower's timeline, if the follower is a local user.
+func mergeFollow(followID string) error {
+	var userID, actor string
+	err := db.QueryRow(`
+        SELECT f.user_id, f.actor
+        FROM followers f
+        JOIN users u ON u.id = f.user_id
+        WHERE f.id = ? AND f.accepted = TRUE
+    `, followID).Scan(&userID, &actor)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fillHomeTimeline(userID, "(SELECT ?1)", actor)
+}
+
+// unmergeFollow takes an unfollowed actor's posts and boosts out of the
+// user's timeline.
+func unmergeFollow(userID, actor string) error {
+	_, err := db.Exec(`
+        DELETE FROM home_timeline
+        WHERE user_id = ?1
+        AND ((author_id = ?2 AND boosted_by IS NULL) OR boosted_by = ?2)
+    `, userID, actor)
+	return err
+}
+
+// RebuildHomeTimelines refills the home timeline of one user, or of every
+// user when username is empty, from their follows.
+func RebuildHomeTimelines(username string) error {
+	query := "SELECT id, username FROM users"
+	var args []interface{}
+	if username != "" {
+		query += " WHERE username = ?"
+		args = append(args, username)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	var users [][2]string
+	for rows.Next() {
+		var user [2]string
+		if err := rows.Scan(&user[0], &user[1]); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if username != "" && len(users) == 0 {
+		return sql.ErrNoRows
+	}
+
+	for _, user := range users {
+		if err := rebuildHomeTimeline(user[0]); err != nil {
+			return err
+		}
+		log.Printf("Rebuilt home timeline of @%s", user[1])
+	}
+	return nil
+}
+
+func rebuildHomeTimeline(userID string) error {
+	if _, err := db.Exec("DELETE FROM home_timeline WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return fillHomeTimeline(userID, "("+followedActorIDs+")", userID)
+}
+
+// GetFollowingTimeline returns a page of the user's home timeline, newest
+// first. Deleted posts, silenced accounts the user doesn't follow and anyone
+// the user hides are left out by the query, so every page is full until the
+// timeline ends.
+func GetFollowingTimeline(userID string, page Page) ([]Post, PageCursors, error) {
+	limit := page.limit(timelinePageSize)
+	pageWhere, pageArgs := page.where("(h.created_at, h.id)", "SELECT created_at, id FROM home_timeline WHERE id = ?")
+
+	args := append([]interface{}{userID, time.Now()}, pageArgs...)
+	rows, err := db.Query(`
+        SELECT h.id, h.post_id, COALESCE(`+actorRef("h.boosted_by")+`, '')
+        FROM home_timeline h
+        WHERE h.user_id = ?1
+        AND (EXISTS (SELECT 1 FROM posts WHERE id = h.post_id)
+             OR EXISTS (SELECT 1 FROM remote_posts WHERE id = h.post_id AND deleted_at IS NULL))
+        AND h.author_id NOT IN (`+hiddenActorIDs+`
+            UNION`+silencedActorIDs+`)
+        AND COALESCE(h.boosted_by, '') NOT IN (`+hiddenActorIDs+`)
+        AND COALESCE(`+replyParentAuthorID("h.post_id")+`, '') NOT IN (`+hiddenActorIDs+`)
+        AND `+pageWhere+`
+        ORDER BY `+page.order("h.created_at", "h.id")+`
+        LIMIT ?
+    `, append(args, limit)...)
+	if err != nil {
+		return nil, PageCursors{}, err
+	}
+
+	type entry struct {
+		id        string
+		postID    string
+		boostedBy string
+	}
+	var entries []entry
+	var ids []string
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.postID, &e.boostedBy); err != nil {
+			rows.Close()
+			return nil, PageCursors{}, err
+		}
+		entries = append(entries, e)
+		ids = append(ids, e.id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, PageCursors{}, err
+	}
+
+	cursors := page.cursors(ids, limit)
+	if page.MinID != "" {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+
+	boosters := make(map[string]*Profile)
+	var posts []Post
+	for _, e := range entries {
+		post, err := getTimelinePost(e.postID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, PageCursors{}, err
+		}
+
+		if e.boostedBy != "" {
+			if _, ok := boosters[e.boostedBy]; !ok {
+				boosters[e.boostedBy] = timelineActor(e.boostedBy)
+			}
+			post.BoostedBy = boosters[e.boostedBy]
+		}
+		posts = append(posts, *post)
+	}
+	return posts, cursors, nil
+}
+
+// replyParentAuthorID is an SQL expression for the actor ID of the author
+// of the post that the post in column replies to, if it is stored here.
+func replyParentAuthorID(column string) string {
+	return `COALESCE(
+            (SELECT ` + postAuthorID("p.reply_to") + ` FROM posts p WHERE p.id = ` + column + `),
+            (SELECT ` + postAuthorID("r.in_reply_to") + ` FROM remote_posts r WHERE r.id = ` + column + `))`
+}
+
+// getTimelinePost loads a local or stored remote post on its own, outside
+// of its thread.
+func getTimelinePost(postID string) (*Post, error) {
+	if strings.HasPrefix(postID, "http") {
+		posts, err := getRemotePosts(`
+            SELECT `+remotePostColumns+`
+            FROM remote_posts
+            WHERE id = ? AND deleted_at IS NULL
+        `, postID)
+		if err != nil {
+			return nil, err
+		}
+		if len(posts) == 0 {
+			return nil, sql.ErrNoRows
+		}
+		return &posts[0], nil
+	}
+
+	post, err := GetPost(postID)
+	if err != nil {
+		return nil, err
+	}
+	author, err := GetProfileByID(post.UserID)
+	if err != nil {
+		return nil, err
+	}
+	post.AuthorID = post.UserID
+	post.Author = *author
+	post.IsLocal = true
+	post.ReplyDepth = 0
+	return post, nil
+}
+
+// timelineActor returns the profile of an actor ref for display.
+func timelineActor(ref string) *Profile {
+	if strings.HasPrefix(ref, "http") {
+		return remoteAuthor(ref)
+	}
+	profile, err := GetProfileByID(ref)
+	if err != nil {
+		return &Profile{ID: ref}
+	}
+	return profile
+}
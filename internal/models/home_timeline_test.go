@@ -0,0 +1,198 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAddToHomeTimelines(t *testing.T) {
+	users := make(map[string]string)
+	for _, name := range []string{"tl_alice", "tl_bob", "tl_carol", "tl_dave"} {
+		user, err := CreateUser(name, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user.ID
+	}
+	alice, bob, carol, dave := users["tl_alice"], users["tl_bob"], users["tl_carol"], users["tl_dave"]
+
+	// bob follows alice and carol follows bob; dave's follow of alice is
+	// still pending, and a remote follower of alice has no timeline here
+	for _, follow := range [][2]string{{bob, alice}, {carol, bob}} {
+		if _, err := CreateFollowRequest(follow[0], follow[1], ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := CreatePendingFollow(dave, alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateFollowRequest("https://remote.example/users/zed", alice, "https://remote.example/follows/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		postID    string
+		authorID  string
+		boostedBy string
+		// want maps the users whose timelines get the post to its booster
+		want map[string]string
+	}{
+		{"post reaches followers and author", "tl-post-1", alice, "", map[string]string{alice: "", bob: ""}},
+		{"post without followers", "tl-post-2", carol, "", map[string]string{carol: ""}},
+		{"boost reaches the booster's followers", "tl-post-3", alice, bob, map[string]string{carol: bob}},
+		{"adding again changes nothing", "tl-post-1", alice, "", map[string]string{alice: "", bob: ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := addToHomeTimelines(tt.postID, tt.authorID, tt.boostedBy, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := db.Query(
+				"SELECT user_id, COALESCE(boosted_by, '') FROM home_timeline WHERE post_id = ?",
+				tt.postID,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+
+			got := make(map[string]string)
+			for rows.Next() {
+				var userID, boostedBy string
+				if err := rows.Scan(&userID, &boostedBy); err != nil {
+					t.Fatal(err)
+				}
+				if _, dup := got[userID]; dup {
+					t.Errorf("post %s is on the timeline of %s twice", tt.postID, userID)
+				}
+				got[userID] = boostedBy
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("timelines = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFollowingTimelineSkipsHidden(t *testing.T) {
+	users := make(map[string]string)
+	for _, name := range []string{"hp_alice", "hp_bob", "hp_carol"} {
+		user, err := CreateUser(name, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user.ID
+	}
+	alice, bob, carol := users["hp_alice"], users["hp_bob"], users["hp_carol"]
+
+	// alice follows bob and carol, whose posts alternate, and mutes carol
+	for _, followed := range []string{bob, carol} {
+		if _, err := CreateFollowRequest(alice, followed, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var bobPosts []string
+	for i := 0; i < 3; i++ {
+		for _, author := range []string{bob, carol} {
+			post, err := CreatePost("post", author)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if author == bob {
+				bobPosts = append([]string{post.ID}, bobPosts...)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := MuteActor(alice, carol, false, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	page := Page{Limit: 2}
+	for i := 0; i < 3; i++ {
+		posts, cursors, err := GetFollowingTimeline(alice, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursors.Older != "" && len(posts) < page.Limit {
+			t.Errorf("page %d has %d posts and a next page", i, len(posts))
+		}
+		for _, p := range posts {
+			got = append(got, p.ID)
+		}
+		if cursors.Older == "" {
+			break
+		}
+		page.MaxID = cursors.Older
+	}
+
+	if !reflect.DeepEqual(got, bobPosts) {
+		t.Errorf("timeline = %v, want %v", got, bobPosts)
+	}
+}
+
+func TestSilencedAccountsStayVisibleToFollowers(t *testing.T) {
+	users := make(map[string]string)
+	for _, name := range []string{"si_alice", "si_bob", "si_carol"} {
+		user, err := CreateUser(name, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user.ID
+	}
+	alice, bob, carol := users["si_alice"], users["si_bob"], users["si_carol"]
+
+	// alice follows bob, carol doesn't, and moderators silence bob
+	if _, err := CreateFollowRequest(alice, bob, ""); err != nil {
+		t.Fatal(err)
+	}
+	post, err := CreatePost("post", bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := moderateActor(bob, ReportSilence, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	timelineHas := func(posts []Post) bool {
+		for _, p := range posts {
+			if p.ID == post.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	tests := []struct {
+		name   string
+		get    func(string, Page) ([]Post, PageCursors, error)
+		viewer string
+		want   bool
+	}{
+		{"home timeline of a follower", GetFollowingTimeline, alice, true},
+		{"own home timeline", GetFollowingTimeline, bob, true},
+		{"local timeline of a follower", GetLocalTimeline, alice, false},
+		{"local timeline of anyone else", GetLocalTimeline, carol, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			posts, _, err := tt.get(tt.viewer, Page{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := timelineHas(posts); got != tt.want {
+				t.Errorf("post shown = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
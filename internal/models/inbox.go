@@ -0,0 +1,191 @@
+package models
+
+import (
+	"Aervyn/internal/activitypub"
+	"Aervyn/internal/config"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// AddInboxRecipients records the local users an activity was delivered to
+// and returns those that were not recorded already.
+func AddInboxRecipients(activityID string, userIDs []string) ([]string, error) {
+	var added []string
+	for _, userID := range userIDs {
+		result, err := db.Exec(`
+            INSERT INTO inbox_recipients (activity_id, user_id)
+            VALUES (?, ?)
+            ON CONFLICT DO NOTHING
+        `, activityID, userID)
+		if err != nil {
+			return added, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			added = append(added, userID)
+		}
+	}
+	return added, nil
+}
+
+// addressing is the audience of an activity or object.
+type addressing struct {
+	To       interface{} `json:"to"`
+	Cc       interface{} `json:"cc"`
+	Bto      interface{} `json:"bto"`
+	Bcc      interface{} `json:"bcc"`
+	Audience interface{} `json:"audience"`
+}
+
+// addresses flattens the audience fields, which may each be a single
+// string or a list.
+func (ad addressing) addresses() []string {
+	var result []string
+	for _, field := range []interface{}{ad.To, ad.Cc, ad.Bto, ad.Bcc, ad.Audience} {
+		result = append(result, addressList(field)...)
+	}
+	return result
+}
+
+// addressList returns the addresses in one audience field.
+func addressList(field interface{}) []string {
+	var result []string
+	switch v := field.(type) {
+	case string:
+		result = append(result, v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+// localUserID returns the ID of the local user with the given actor URI.
+func localUserID(actorURI string) (string, bool) {
+	prefix := config.GetActorURL("")
+	if !strings.HasPrefix(actorURI, prefix) {
+		return "", false
+	}
+	username := strings.TrimPrefix(actorURI, prefix)
+	if username == "" || strings.Contains(username, "/") {
+		return "", false
+	}
+
+	user, err := GetUserByUsername(username)
+	if err != nil {
+		return "", false
+	}
+	return user.ID, true
+}
+
+// ResolveRecipients works out which local users a shared inbox delivery is
+// for: users addressed directly or referenced by the object, authors of
+// local posts being interacted with, and local followers of the sender
+// when it addressed the public or its followers.
+func (a *Activity) ResolveRecipients() ([]string, error) {
+	var raw struct {
+		addressing
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal([]byte(a.RawData), &raw); err != nil {
+		return nil, err
+	}
+
+	addresses := raw.addresses()
+	var candidates []string
+
+	if len(raw.Object) > 0 {
+		var objectAddressing addressing
+		if json.Unmarshal(raw.Object, &objectAddressing) == nil {
+			addresses = append(addresses, objectAddressing.addresses()...)
+		}
+
+		if obj, err := ParseObject(raw.Object); err == nil {
+			// Follow of a local user, or Accept/Reject of one's Follow
+			candidates = append(candidates, obj.ID, obj.Actor)
+			// Undo{Follow} names the unfollowed user in the inner object
+			if inner, err := ParseObject(obj.Object); err == nil {
+				candidates = append(candidates, inner.ID)
+			}
+			// Like or Announce of a local post
+			if postID, ok := localPostID(obj.ID); ok {
+				candidates = append(candidates, storedPostAuthor(postID))
+			}
+		}
+	}
+	candidates = append(candidates, addresses...)
+
+	seen := make(map[string]bool)
+	var recipients []string
+	add := func(userID string) {
+		if !seen[userID] {
+			seen[userID] = true
+			recipients = append(recipients, userID)
+		}
+	}
+
+	for _, candidate := range candidates {
+		if userID, ok := localUserID(candidate); ok {
+			add(userID)
+		}
+	}
+
+	if toFollowers(addresses, a.Actor) {
+		followers, err := localFollowersOf(a.Actor)
+		if err != nil {
+			return nil, err
+		}
+		for _, userID := range followers {
+			add(userID)
+		}
+	}
+
+	return recipients, nil
+}
+
+// toFollowers reports whether an activity is addressed to the public or to
+// a followers collection on the sender's server.
+func toFollowers(addresses []string, actor string) bool {
+	actorURL, err := url.Parse(actor)
+	if err != nil {
+		return false
+	}
+
+	for _, address := range addresses {
+		if address == activitypub.PublicAddress || address == "as:Public" || address == "Public" {
+			return true
+		}
+		u, err := url.Parse(address)
+		if err == nil && u.Host == actorURL.Host && strings.HasSuffix(u.Path, "/followers") {
+			return true
+		}
+	}
+	return false
+}
+
+// localFollowersOf returns the local users with an accepted follow of actor.
+func localFollowersOf(actor string) ([]string, error) {
+	rows, err := db.Query(`
+        SELECT f.user_id
+        FROM followers f
+        JOIN users u ON u.id = f.user_id
+        WHERE f.actor = ? AND f.accepted = true
+    `, findActorID(actor))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
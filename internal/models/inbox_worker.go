@@ -0,0 +1,229 @@
+package models
+
+import (
+	"Aervyn/internal/activitypub"
+	"errors"
+	"hash/fnv"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	maxInboxAttempts = 8
+	inboxBatchSize   = 50
+	inboxWorkers     = 4
+	inboxInterval    = 5 * time.Second
+)
+
+// inboxWake lets the inbox handlers start the worker without waiting for
+// the next poll.
+var inboxWake = make(chan struct{}, 1)
+
+// WakeInboxWorker asks the inbox worker to look for new activities.
+func WakeInboxWorker() {
+	select {
+	case inboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartInboxWorker processes stored inbox activities in the background.
+// Like deliveries, the queue lives in the database and survives restarts.
+func StartInboxWorker() {
+	go func() {
+		ticker := time.NewTicker(inboxInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-inboxWake:
+			}
+			if err := processDueInboxActivities(); err != nil {
+				log.Printf("Error processing inbox: %v", err)
+			}
+		}
+	}()
+}
+
+// processDueInboxActivities runs one batch of due activities. Activities
+// from the same actor always go to the same worker so they are handled in
+// the order they arrived, e.g. a Create before its Delete.
+func processDueInboxActivities() error {
+	rows, err := db.Query(`
+        SELECT id, user_id, activity_type, actor, COALESCE(object_id, ''), raw_data, created_at, processed, attempts
+        FROM inbox_activities a
+        WHERE failed = FALSE
+        AND (processed = FALSE OR EXISTS (
+            SELECT 1 FROM inbox_recipients r
+            WHERE r.activity_id = a.id AND r.processed = FALSE
+        ))
+        AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+        ORDER BY created_at ASC
+        LIMIT ?
+    `, time.Now(), inboxBatchSize)
+	if err != nil {
+		return err
+	}
+
+	type job struct {
+		activity Activity
+		attempts int
+	}
+	var due []job
+	for rows.Next() {
+		var j job
+		a := &j.activity
+		err := rows.Scan(&a.ID, &a.UserID, &a.Type, &a.Actor, &a.ObjectID, &a.RawData, &a.CreatedAt, &a.Processed, &j.attempts)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, j)
+	}
+	rows.Close()
+
+	queues := make([]chan job, inboxWorkers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan job, len(due))
+		wg.Add(1)
+		go func(jobs chan job) {
+			defer wg.Done()
+			for j := range jobs {
+				if err := j.activity.processPending(); err != nil {
+					j.activity.fail(err, j.attempts)
+				}
+			}
+		}(queues[i])
+	}
+	for _, j := range due {
+		h := fnv.New32a()
+		h.Write([]byte(j.activity.Actor))
+		queues[h.Sum32()%inboxWorkers] <- j
+	}
+	for _, jobs := range queues {
+		close(jobs)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// processPending does whatever is left to do for an activity. Follow,
+// Accept, Reject, Undo and Block act on a recipient's own follows and run
+// once per recipient; everything else changes shared state and runs once.
+// Finished work is marked so a retry, or a redelivery, never repeats it.
+func (a *Activity) processPending() error {
+	// The domain may have been suspended since the activity arrived
+	if err := CheckFederation(a.Actor); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`
+        SELECT user_id FROM inbox_recipients
+        WHERE activity_id = ? AND processed = FALSE
+    `, a.ID)
+	if err != nil {
+		return err
+	}
+	var recipients []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		recipients = append(recipients, userID)
+	}
+	rows.Close()
+
+	switch a.Type {
+	case "Follow", "Accept", "Reject", "Undo", "Block":
+		for _, userID := range recipients {
+			scoped := *a
+			scoped.UserID = userID
+			if err := scoped.ProcessActivity(); err != nil {
+				return err
+			}
+			if err := markRecipientProcessed(a.ID, userID); err != nil {
+				return err
+			}
+		}
+	default:
+		if !a.Processed {
+			if err := a.ProcessActivity(); err != nil {
+				return err
+			}
+		}
+		for _, userID := range recipients {
+			if err := markRecipientProcessed(a.ID, userID); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = db.Exec(`
+        UPDATE inbox_activities
+        SET processed = TRUE, attempts = attempts + 1, last_error = NULL
+        WHERE id = ?
+    `, a.ID)
+	return err
+}
+
+func markRecipientProcessed(activityID, userID string) error {
+	_, err := db.Exec(`
+        UPDATE inbox_recipients SET processed = TRUE
+        WHERE activity_id = ? AND user_id = ?
+    `, activityID, userID)
+	return err
+}
+
+// fail schedules a retry for transient errors and dead-letters the
+// activity otherwise, or once it has run out of attempts.
+func (a *Activity) fail(cause error, attempts int) {
+	attempts++
+	failed := !isTransient(cause) || attempts >= maxInboxAttempts
+
+	state := "retrying"
+	if failed {
+		state = "dead"
+	}
+	log.Printf("Processing activity %s failed (attempt %d, %s): %v", a.ID, attempts, state, cause)
+
+	_, err := db.Exec(`
+        UPDATE inbox_activities
+        SET failed = ?, attempts = ?, next_attempt_at = ?, last_error = ?
+        WHERE id = ?
+    `, failed, attempts, time.Now().Add(deliveryBackoff(attempts)), cause.Error(), a.ID)
+	if err != nil {
+		log.Printf("Error updating activity %s: %v", a.ID, err)
+	}
+}
+
+// isTransient reports whether an error may go away on its own: a busy
+// database, a network failure while fetching or delivering, or a remote
+// server that failed or rate limited us.
+func isTransient(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+
+	var fetchErr *FetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr.Transient()
+	}
+
+	var deliveryErr *activitypub.DeliveryError
+	if errors.As(err, &deliveryErr) {
+		return !deliveryErr.Permanent()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"Aervyn/internal/activitypub"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"database busy", sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{"database locked", sqlite3.Error{Code: sqlite3.ErrLocked}, true},
+		{"constraint violated", sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{"network failure", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"fetch server error", &FetchError{StatusCode: 503}, true},
+		{"fetch rate limited", &FetchError{StatusCode: 429}, true},
+		{"fetch not found", &FetchError{StatusCode: 404}, false},
+		{"fetch gone", &FetchError{StatusCode: 410}, false},
+		{"wrapped fetch error", fmt.Errorf("resolving actor: %w", &FetchError{StatusCode: 502}), true},
+		{"delivery server error", &activitypub.DeliveryError{StatusCode: 500}, true},
+		{"delivery rejected", &activitypub.DeliveryError{StatusCode: 403}, false},
+		{"other error", errors.New("malformed activity"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"Aervyn/internal/config"
+	"database/sql"
+	"sync"
+)
+
+// InstanceActorID stands in for a user ID in deliveries sent on behalf of
+// the instance itself, such as forwarded reports.
+const InstanceActorID = "instance"
+
+var instanceKeyMu sync.Mutex
+
+// InstanceActorURL is the ActivityPub ID of the instance actor.
+func InstanceActorURL() string {
+	return config.InstanceURL + "/actor"
+}
+
+// GetInstanceKeys returns the instance actor's key pair, creating it on
+// first use.
+func GetInstanceKeys() (publicKey, privateKey string, err error) {
+	instanceKeyMu.Lock()
+	defer instanceKeyMu.Unlock()
+
+	err = db.QueryRow(
+		"SELECT public_key, private_key FROM instance_actor WHERE id = 1",
+	).Scan(&publicKey, &privateKey)
+	if err != sql.ErrNoRows {
+		return publicKey, privateKey, err
+	}
+
+	publicKey, privateKey, err = generateKeyPair()
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO instance_actor (id, public_key, private_key) VALUES (1, ?, ?)",
+		publicKey, privateKey,
+	)
+	return publicKey, privateKey, err
+}
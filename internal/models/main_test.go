@@ -0,0 +1,27 @@
+package models
+
+import (
+	"log"
+	"os"
+	"testing"
+)
+
+// TestMain runs the tests against a fresh database in a temporary
+// directory, since InitDB opens posts.db in the working directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "models-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+	if err := InitDB(); err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+	db.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// OutboxItem is one entry of a user's outbox: a Create for one of their own
+// posts or an Announce for one of their boosts.
+type OutboxItem struct {
+	ID        string // post ID for Create, boost ID for Announce
+	Type      string
+	Post      Post // the created post, or only the ID of the boosted post
+	CreatedAt time.Time
+}
+
+const outboxItemsQuery = `
+        WITH items AS (
+            SELECT 'Create' AS type, p.id, p.id AS post_id, p.content, p.reply_to, p.created_at
+            FROM posts p
+            WHERE p.user_id = ?1
+            UNION ALL
+            SELECT 'Announce' AS type, b.id, b.post_id, '', NULL, b.created_at
+            FROM boosts b
+            WHERE b.user_id = ?1
+        )
+`
+
+// CountOutboxItems returns the number of activities in a user's outbox.
+func CountOutboxItems(userID string) (int, error) {
+	var count int
+	err := db.QueryRow(outboxItemsQuery+`SELECT COUNT(*) FROM items`, userID).Scan(&count)
+	return count, err
+}
+
+// GetOutboxItems returns a page of a user's outbox, newest first. maxID
+// returns items older than that item, minID items newer than it; minID "0"
+// returns the oldest page.
+func GetOutboxItems(userID, maxID, minID string, limit int) ([]OutboxItem, error) {
+	var query string
+	var args []interface{}
+
+	switch {
+	case minID == "0":
+		query = outboxItemsQuery + `
+        SELECT type, id, post_id, content, reply_to, created_at FROM items
+        ORDER BY created_at ASC, id ASC
+        LIMIT ?2`
+		args = []interface{}{userID, limit}
+	case minID != "":
+		query = outboxItemsQuery + `
+        SELECT type, id, post_id, content, reply_to, created_at FROM items
+        WHERE (created_at, id) > (SELECT created_at, id FROM items WHERE id = ?2)
+        ORDER BY created_at ASC, id ASC
+        LIMIT ?3`
+		args = []interface{}{userID, minID, limit}
+	case maxID != "":
+		query = outboxItemsQuery + `
+        SELECT type, id, post_id, content, reply_to, created_at FROM items
+        WHERE (created_at, id) < (SELECT created_at, id FROM items WHERE id = ?2)
+        ORDER BY created_at DESC, id DESC
+        LIMIT ?3`
+		args = []interface{}{userID, maxID, limit}
+	default:
+		query = outboxItemsQuery + `
+        SELECT type, id, post_id, content, reply_to, created_at FROM items
+        ORDER BY created_at DESC, id DESC
+        LIMIT ?2`
+		args = []interface{}{userID, limit}
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []OutboxItem
+	for rows.Next() {
+		var item OutboxItem
+		var replyTo sql.NullString
+		err := rows.Scan(&item.Type, &item.ID, &item.Post.ID, &item.Post.Content, &replyTo, &item.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Post.UserID = userID
+		item.Post.CreatedAt = item.CreatedAt
+		if replyTo.Valid {
+			item.Post.ReplyTo = &replyTo.String
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Pages are always served newest first
+	if minID != "" {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	return items, nil
+}
+
+// HasOlderOutboxItems reports whether a user's outbox has items older than
+// the given one.
+func HasOlderOutboxItems(userID, itemID string) (bool, error) {
+	var older bool
+	err := db.QueryRow(outboxItemsQuery+`
+        SELECT EXISTS(
+            SELECT 1 FROM items
+            WHERE (created_at, id) < (SELECT created_at, id FROM items WHERE id = ?2)
+        )`, userID, itemID).Scan(&older)
+	return older, err
+}
@@ -0,0 +1,332 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	outboxPollBatch    = 20
+	outboxPollers      = 4
+	outboxPollInterval = time.Minute
+
+	// Quiet accounts are polled less and less often, down to
+	// outboxMaxInterval, and back to outboxMinInterval once they post.
+	outboxMinInterval = 15 * time.Minute
+	outboxMaxInterval = 6 * time.Hour
+)
+
+var outboxClient = &http.Client{Timeout: 30 * time.Second}
+
+// outboxWake lets a new follow be polled without waiting for the next tick.
+var outboxWake = make(chan struct{}, 1)
+
+// WakeOutboxPoller asks the poller to look for due outboxes now.
+func WakeOutboxPoller() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartOutboxPoller keeps remote_posts up to date with the outboxes of
+// remote actors that local users follow, so timelines never wait on
+// remote servers.
+func StartOutboxPoller() {
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := pollDueOutboxes(); err != nil {
+				log.Printf("Error polling outboxes: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-outboxWake:
+			}
+		}
+	}()
+}
+
+// outboxPoll is the polling schedule of one remote actor.
+type outboxPoll struct {
+	ActorID      string
+	URI          string
+	ETag         string
+	LastModified string
+	Interval     time.Duration
+	Failures     int
+}
+
+// pollDueOutboxes polls one batch of followed actors whose next poll is
+// due, never-polled actors first.
+func pollDueOutboxes() error {
+	rows, err := db.Query(`
+        SELECT a.id, a.uri, COALESCE(p.etag, ''), COALESCE(p.last_modified, ''),
+               COALESCE(p.interval_seconds, 0), COALESCE(p.failures, 0)
+        FROM actors a
+        LEFT JOIN outbox_polls p ON p.actor_id = a.id
+        WHERE NOT a.local
+        AND EXISTS (
+            SELECT 1 FROM followers f
+            JOIN users u ON u.id = f.user_id
+            WHERE f.actor = a.id AND f.accepted = TRUE
+        )
+        AND (p.next_poll_at IS NULL OR p.next_poll_at <= ?)
+        ORDER BY p.next_poll_at ASC
+        LIMIT ?
+    `, time.Now(), outboxPollBatch)
+	if err != nil {
+		return err
+	}
+
+	var due []*outboxPoll
+	for rows.Next() {
+		var p outboxPoll
+		var seconds int
+		if err := rows.Scan(&p.ActorID, &p.URI, &p.ETag, &p.LastModified, &seconds, &p.Failures); err != nil {
+			rows.Close()
+			return err
+		}
+		p.Interval = time.Duration(seconds) * time.Second
+		due = append(due, &p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	jobs := make(chan *outboxPoll, len(due))
+	for _, p := range due {
+		jobs <- p
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < outboxPollers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				stored, err := p.fetch()
+				if err != nil {
+					log.Printf("Error polling outbox of %s: %v", p.URI, err)
+				}
+				if err := p.schedule(stored, err); err != nil {
+					log.Printf("Error scheduling outbox poll of %s: %v", p.URI, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// fetch reads the first page of the actor's outbox and stores its Notes,
+// returning how many were new. An unchanged outbox costs one conditional
+// request.
+func (p *outboxPoll) fetch() (int, error) {
+	if err := CheckFederation(p.URI); err != nil {
+		return 0, err
+	}
+
+	profile, err := FetchRemoteProfile(p.URI)
+	if err != nil {
+		return 0, err
+	}
+	if profile.OutboxURL == "" {
+		return 0, fmt.Errorf("actor %s has no outbox", p.URI)
+	}
+
+	req, err := http.NewRequest("GET", profile.OutboxURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/activity+json")
+	if p.ETag != "" {
+		req.Header.Set("If-None-Match", p.ETag)
+	}
+	if p.LastModified != "" {
+		req.Header.Set("If-Modified-Since", p.LastModified)
+	}
+
+	resp, err := outboxClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, &FetchError{URL: profile.OutboxURL, StatusCode: resp.StatusCode}
+	}
+
+	var outbox struct {
+		First        json.RawMessage   `json:"first"`
+		OrderedItems []json.RawMessage `json:"orderedItems"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&outbox); err != nil {
+		return 0, err
+	}
+
+	items := outbox.OrderedItems
+	if len(items) == 0 && len(outbox.First) > 0 {
+		items, err = fetchOutboxPage(outbox.First)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Only remember validators once the outbox was read successfully
+	p.ETag = resp.Header.Get("ETag")
+	p.LastModified = resp.Header.Get("Last-Modified")
+
+	stored := 0
+	for _, item := range items {
+		isNew, err := p.storeItem(item)
+		if err != nil {
+			log.Printf("Skipping outbox item from %s: %v", p.URI, err)
+			continue
+		}
+		if isNew {
+			stored++
+		}
+	}
+	return stored, nil
+}
+
+// fetchOutboxPage returns the items of an outbox's first page, which may
+// be embedded or given by URL.
+func fetchOutboxPage(first json.RawMessage) ([]json.RawMessage, error) {
+	var page struct {
+		OrderedItems []json.RawMessage `json:"orderedItems"`
+	}
+
+	var pageURL string
+	if err := json.Unmarshal(first, &pageURL); err != nil {
+		if err := json.Unmarshal(first, &page); err != nil {
+			return nil, err
+		}
+		return page.OrderedItems, nil
+	}
+
+	if err := CheckFederation(pageURL); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/activity+json")
+
+	resp, err := outboxClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &FetchError{URL: pageURL, StatusCode: resp.StatusCode}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return page.OrderedItems, nil
+}
+
+// storeItem stores the Note of a Create in the outbox, reporting whether
+// we didn't have it yet. Other activities are skipped.
+func (p *outboxPoll) storeItem(item json.RawMessage) (bool, error) {
+	var activity struct {
+		Type   string          `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal(item, &activity); err != nil {
+		return false, err
+	}
+	if activity.Type != "Create" {
+		return false, nil
+	}
+
+	var note RemoteNote
+	if err := json.Unmarshal(activity.Object, &note); err != nil {
+		// Objects given only by reference aren't worth a request each
+		return false, nil
+	}
+	if note.Type != "Note" || note.ID == "" {
+		return false, nil
+	}
+	if note.AttributedTo == "" {
+		note.AttributedTo = p.URI
+	}
+	if note.AttributedTo != p.URI {
+		return false, fmt.Errorf("note %s is attributed to %s", note.ID, note.AttributedTo)
+	}
+	if hostOf(note.ID) != hostOf(p.URI) {
+		return false, fmt.Errorf("note %s is not hosted by %s", note.ID, p.URI)
+	}
+	if blockedReply(&note) {
+		return false, nil
+	}
+
+	// Notes we already have are left alone, so tombstones stay deleted
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM remote_posts WHERE id = ?)", note.ID).Scan(&exists)
+	if err != nil || exists {
+		return false, err
+	}
+	if err := StoreRemoteNote(&note); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// schedule works out the next poll: soon after new posts, backing off
+// while the outbox stays quiet and on errors.
+func (p *outboxPoll) schedule(stored int, cause error) error {
+	now := time.Now()
+	var lastError interface{}
+	if cause != nil {
+		p.Failures++
+		lastError = cause.Error()
+	} else {
+		p.Failures = 0
+		switch {
+		case stored > 0 || p.Interval == 0:
+			p.Interval = outboxMinInterval
+		case p.Interval < outboxMaxInterval:
+			p.Interval *= 2
+			if p.Interval > outboxMaxInterval {
+				p.Interval = outboxMaxInterval
+			}
+		}
+	}
+
+	next := now.Add(p.Interval)
+	if cause != nil {
+		next = now.Add(deliveryBackoff(p.Failures))
+	}
+
+	_, err := db.Exec(`
+        INSERT INTO outbox_polls
+        (actor_id, etag, last_modified, interval_seconds, failures, last_error, polled_at, next_poll_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(actor_id) DO UPDATE SET
+        etag = excluded.etag,
+        last_modified = excluded.last_modified,
+        interval_seconds = excluded.interval_seconds,
+        failures = excluded.failures,
+        last_error = excluded.last_error,
+        polled_at = excluded.polled_at,
+        next_poll_at = excluded.next_poll_at
+    `, p.ActorID, p.ETag, p.LastModified, int(p.Interval/time.Second), p.Failures, lastError, now, next)
+	return err
+}
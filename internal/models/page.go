@@ -0,0 +1,146 @@
+package models
+
+import "strings"
+
+// Page selects part of a timeline by cursor, as in Mastodon's API: MaxID
+// returns entries older than that entry, SinceID the newest entries newer
+// than it, and MinID the entries right after it. Cursors are the IDs the
+// timeline is keyed by; for threaded timelines that is the root post.
+type Page struct {
+	MaxID   string
+	SinceID string
+	MinID   string
+	Limit   int
+}
+
+// PageCursors are the cursors of the pages around one just read: Older
+// goes in max_id and Newer in min_id. Older is empty on the last page.
+type PageCursors struct {
+	Older string
+	Newer string
+}
+
+const (
+	// Flat timelines page by entry, threaded ones by thread
+	timelinePageSize = 40
+	threadPageSize   = 20
+
+	// MaxPageSize caps the limit a client may ask for.
+	MaxPageSize = 80
+)
+
+// HasCursor reports whether the page is anything but the newest one.
+func (p Page) HasCursor() bool {
+	return p.MaxID != "" || p.SinceID != "" || p.MinID != ""
+}
+
+func (p Page) limit(defaultLimit int) int {
+	if p.Limit <= 0 {
+		return defaultLimit
+	}
+	if p.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return p.Limit
+}
+
+// where returns the SQL condition keeping rows inside the page and its
+// arguments. key is the row value the timeline is sorted by and cursorKey
+// a query selecting that row value for the cursor given as its one ?.
+func (p Page) where(key, cursorKey string) (string, []interface{}) {
+	conds := []string{"TRUE"}
+	var args []interface{}
+	if p.MaxID != "" {
+		conds = append(conds, key+" < ("+cursorKey+")")
+		args = append(args, p.MaxID)
+	}
+	if p.SinceID != "" {
+		conds = append(conds, key+" > ("+cursorKey+")")
+		args = append(args, p.SinceID)
+	}
+	if p.MinID != "" {
+		conds = append(conds, key+" > ("+cursorKey+")")
+		args = append(args, p.MinID)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// order returns the ORDER BY terms for the page. Reading forward from
+// MinID fetches oldest first; the rows are put back newest first after.
+func (p Page) order(columns ...string) string {
+	direction := " DESC"
+	if p.MinID != "" {
+		direction = " ASC"
+	}
+	return strings.Join(columns, direction+", ") + direction
+}
+
+// cursors works out the cursors around a page from the IDs read for it,
+// in the order the query returned them.
+func (p Page) cursors(ids []string, limit int) PageCursors {
+	var c PageCursors
+	if len(ids) == 0 {
+		return c
+	}
+	if p.MinID != "" {
+		reverseStrings(ids)
+	}
+
+	c.Newer = ids[0]
+	// A short page is the end of the timeline, unless we paged forwards
+	// from a cursor, which always leaves older entries behind
+	if len(ids) == limit || p.MinID != "" || p.SinceID != "" {
+		c.Older = ids[len(ids)-1]
+	}
+	return c
+}
+
+func reverseStrings(s []string) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+// placeholders returns n comma-separated SQL parameters.
+func placeholders(n int) string {
+	if n == 0 {
+		return "NULL"
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// pageThreads returns the root posts of a page of threads, newest first,
+// taking the roots from the local posts matching where.
+func pageThreads(page Page, where string, args ...interface{}) ([]string, PageCursors, error) {
+	limit := page.limit(threadPageSize)
+	pageWhere, pageArgs := page.where("(p.created_at, p.id)", "SELECT created_at, id FROM posts WHERE id = ?")
+
+	rows, err := db.Query(`
+        SELECT p.id
+        FROM posts p
+        WHERE p.reply_to IS NULL
+        AND `+where+`
+        AND `+pageWhere+`
+        ORDER BY `+page.order("p.created_at", "p.id")+`
+        LIMIT ?
+    `, append(append(args, pageArgs...), limit)...)
+	if err != nil {
+		return nil, PageCursors{}, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, PageCursors{}, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageCursors{}, err
+	}
+
+	cursors := page.cursors(append([]string(nil), ids...), limit)
+	return ids, cursors, nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPageWhere(t *testing.T) {
+	const key, cursorKey = "k", "SELECT k FROM t WHERE id = ?"
+	tests := []struct {
+		name     string
+		page     Page
+		wantCond string
+		wantArgs []interface{}
+		wantSort string
+	}{
+		{"newest", Page{}, "TRUE", nil, "a DESC, b DESC"},
+		{"older", Page{MaxID: "9"}, "TRUE AND k < (SELECT k FROM t WHERE id = ?)", []interface{}{"9"}, "a DESC, b DESC"},
+		{"newest since", Page{SinceID: "3"}, "TRUE AND k > (SELECT k FROM t WHERE id = ?)", []interface{}{"3"}, "a DESC, b DESC"},
+		{"right after", Page{MinID: "3"}, "TRUE AND k > (SELECT k FROM t WHERE id = ?)", []interface{}{"3"}, "a ASC, b ASC"},
+		{
+			"between", Page{MaxID: "9", SinceID: "3"},
+			"TRUE AND k < (SELECT k FROM t WHERE id = ?) AND k > (SELECT k FROM t WHERE id = ?)",
+			[]interface{}{"9", "3"}, "a DESC, b DESC",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, args := tt.page.where(key, cursorKey)
+			if cond != tt.wantCond {
+				t.Errorf("where() condition = %q, want %q", cond, tt.wantCond)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("where() args = %v, want %v", args, tt.wantArgs)
+			}
+			if sort := tt.page.order("a", "b"); sort != tt.wantSort {
+				t.Errorf("order() = %q, want %q", sort, tt.wantSort)
+			}
+		})
+	}
+}
+
+func TestPageCursors(t *testing.T) {
+	tests := []struct {
+		name  string
+		page  Page
+		ids   []string
+		limit int
+		want  PageCursors
+	}{
+		{"empty", Page{}, nil, 3, PageCursors{}},
+		{"full page", Page{}, []string{"9", "8", "7"}, 3, PageCursors{Older: "7", Newer: "9"}},
+		{"last page", Page{MaxID: "7"}, []string{"6", "5"}, 3, PageCursors{Newer: "6"}},
+		{"since", Page{SinceID: "2"}, []string{"9", "8"}, 3, PageCursors{Older: "8", Newer: "9"}},
+		{"forwards from min_id", Page{MinID: "5"}, []string{"6", "7"}, 3, PageCursors{Older: "6", Newer: "7"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.page.cursors(tt.ids, tt.limit); got != tt.want {
+				t.Errorf("cursors() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{0, timelinePageSize},
+		{-1, timelinePageSize},
+		{10, 10},
+		{MaxPageSize + 1, MaxPageSize},
+	}
+
+	for _, tt := range tests {
+		if got := (Page{Limit: tt.limit}).limit(timelinePageSize); got != tt.want {
+			t.Errorf("Page{Limit: %d}.limit() = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"Aervyn/internal/activitypub"
+	"log"
+	"time"
+)
+
+// peerSchemeStore persists which signature scheme each remote host accepts.
+type peerSchemeStore struct{}
+
+func (peerSchemeStore) Get(host string) (activitypub.SignatureScheme, bool) {
+	var scheme string
+	err := db.QueryRow(
+		"SELECT signature_scheme FROM peers WHERE host = ?",
+		host,
+	).Scan(&scheme)
+	if err != nil {
+		return "", false
+	}
+	return activitypub.SignatureScheme(scheme), true
+}
+
+func (peerSchemeStore) Set(host string, scheme activitypub.SignatureScheme) {
+	_, err := db.Exec(`
+        INSERT INTO peers (host, signature_scheme, updated_at)
+        VALUES (?, ?, ?)
+        ON CONFLICT(host) DO UPDATE SET
+        signature_scheme = excluded.signature_scheme,
+        updated_at = excluded.updated_at
+    `, host, string(scheme), time.Now())
+	if err != nil {
+		log.Printf("Failed to remember signature scheme for %s: %v", host, err)
+	}
+}
@@ -2,11 +2,7 @@ package models
 
 import (
 	"database/sql"
-	"encoding/json"
-	"fmt"
 	"log"
-	"net/http"
-	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,6 +23,9 @@ type Post struct {
 	ReplyDepth int   `json:"-"`
 	ParentPost *Post `json:"-"`
 
+	// Set on home timeline entries that came in as a boost
+	BoostedBy *Profile `json:"-"`
+
 	// Interaction counts
 	LikeCount  int `json:"likes"`
 	BoostCount int `json:"shares"`
@@ -37,7 +36,17 @@ type Post struct {
 	HasBoosted bool `json:"hasBoosted"`
 }
 
-func GetLocalTimeline() ([]Post, error) {
+// GetLocalTimeline returns a page of local threads, newest first, leaving
+// out anyone the viewer blocks or mutes and every silenced account.
+func GetLocalTimeline(viewerID string, page Page) ([]Post, PageCursors, error) {
+	// Hidden authors are left out of the page itself so it stays full
+	roots, cursors, err := pageThreads(page, `p.user_id NOT IN (`+hiddenActorIDs+`
+            UNION
+            SELECT actor FROM actor_moderation)`, viewerID, time.Now())
+	if err != nil {
+		return nil, PageCursors{}, err
+	}
+
 	query := `
         WITH RECURSIVE thread_posts AS (
             -- Get root posts (non-replies)
@@ -54,7 +63,7 @@ func GetLocalTimeline() ([]Post, error) {
                 CAST(printf('%020d', p.id) AS TEXT) as path
             FROM posts p
             JOIN users u ON p.user_id = u.id
-            WHERE p.reply_to IS NULL
+            WHERE p.id IN (` + placeholders(len(roots)) + `)
             
             UNION ALL
             
@@ -83,140 +92,75 @@ func GetLocalTimeline() ([]Post, error) {
         ORDER BY 
             thread_start DESC,
             path ASC
-        LIMIT 50
     `
 
-	return getPostsFromQuery(query)
-}
-
-type PostWithContext struct {
-	Post       Post
-	ParentID   *string
-	RootID     string
-	ThreadTime time.Time
-}
-
-func GetFollowingTimeline(userID string) ([]Post, error) {
-	// FIXME: Make this much better by actually sorting and timelining the posts correctly
-
-	// First get local posts from followed users
-	localPosts, err := getLocalFollowingPosts(userID)
+	args := make([]interface{}, len(roots))
+	for i, id := range roots {
+		args[i] = id
+	}
+	posts, err := getPostsFromQuery(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, PageCursors{}, err
 	}
+	posts, err = filterHidden(viewerID, posts)
+	return posts, cursors, err
+}
 
-	// Get list of remote users we follow
-	remoteFollows, err := getRemoteFollows(userID)
+func getPostsFromQuery(query string, args ...interface{}) ([]Post, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Fetch remote posts
-	var remotePosts []Post
-	for _, follow := range remoteFollows {
-		posts, err := fetchRemoteUserPosts(follow.Actor)
+	var posts []Post
+	for rows.Next() {
+		var p Post
+		var replyTo sql.NullString
+		err := rows.Scan(
+			&p.ID,
+			&p.AuthorID,
+			&p.Author.Username,
+			&p.Content,
+			&p.CreatedAt,
+			&replyTo,
+			&p.ReplyDepth,
+			&p.LikeCount,
+			&p.BoostCount,
+			&p.ReplyCount,
+		)
 		if err != nil {
-			log.Printf("Error fetching posts from %s: %v", follow.Actor, err)
-			continue // Skip this user if there's an error
+			return nil, err
 		}
-		remotePosts = append(remotePosts, posts...)
-	}
-
-	// Merge all posts
-	allPosts := append(localPosts, remotePosts...)
 
-	// Create a map of posts by ID for quick lookup
-	postMap := make(map[string]Post)
-	for _, post := range allPosts {
-		postMap[post.ID] = post
-	}
-
-	// Create posts with context and find root posts for threads
-	var postsWithContext []PostWithContext
-	for _, post := range allPosts {
-		ctx := PostWithContext{
-			Post:       post,
-			ThreadTime: post.CreatedAt,
+		if replyTo.Valid {
+			p.ReplyTo = &replyTo.String
 		}
 
-		if post.ReplyTo != nil {
-			ctx.ParentID = post.ReplyTo
-			// Find the root post
-			currentID := *post.ReplyTo
-			for i := 0; i < 10; i++ { // Limit depth to prevent infinite loops
-				if parent, ok := postMap[currentID]; ok {
-					if parent.ReplyTo == nil {
-						ctx.RootID = parent.ID
-						ctx.ThreadTime = parent.CreatedAt
-						break
-					}
-					currentID = *parent.ReplyTo
-				} else {
-					break
-				}
-			}
-		} else {
-			ctx.RootID = post.ID
+		author, err := GetProfileByID(p.AuthorID)
+		if err != nil {
+			return nil, err
 		}
+		p.Author = *author
 
-		postsWithContext = append(postsWithContext, ctx)
+		posts = append(posts, p)
 	}
 
-	// Sort posts
-	sort.Slice(postsWithContext, func(i, j int) bool {
-		postI := postsWithContext[i]
-		postJ := postsWithContext[j]
-
-		// If one post is a reply and its parent is nearby (within last 10 posts)
-		if postI.ParentID != nil {
-			parentIndex := -1
-			for k := j; k < len(postsWithContext) && k < j+10; k++ {
-				if postsWithContext[k].Post.ID == *postI.ParentID {
-					parentIndex = k
-					break
-				}
-			}
-			if parentIndex != -1 {
-				return false // Keep reply after its parent
-			}
-		}
-
-		if postJ.ParentID != nil {
-			parentIndex := -1
-			for k := i; k < len(postsWithContext) && k < i+10; k++ {
-				if postsWithContext[k].Post.ID == *postJ.ParentID {
-					parentIndex = k
-					break
-				}
-			}
-			if parentIndex != -1 {
-				return true // Keep reply after its parent
-			}
-		}
-
-		// If posts are in the same thread and close in time
-		if postI.RootID == postJ.RootID {
-			timeDiff := postI.Post.CreatedAt.Sub(postJ.Post.CreatedAt)
-			if timeDiff.Hours() < 24 { // Within 24 hours
-				// Sort by thread position
-				return postI.Post.CreatedAt.Before(postJ.Post.CreatedAt)
+	for i := range posts {
+		if posts[i].ReplyTo != nil {
+			err = posts[i].LoadParentPost()
+			if err != nil {
+				return nil, err
 			}
 		}
-
-		// Default to chronological order
-		return postI.Post.CreatedAt.After(postJ.Post.CreatedAt)
-	})
-
-	// Convert back to regular posts
-	sortedPosts := make([]Post, len(postsWithContext))
-	for i, pc := range postsWithContext {
-		sortedPosts[i] = pc.Post
 	}
 
-	return sortedPosts, nil
+	return posts, nil
 }
 
-func getLocalFollowingPosts(userID string) ([]Post, error) {
+// GetThread returns a post followed by all of its replies, local and
+// remote, in thread order.
+func GetThread(postID string) ([]Post, error) {
 	query := `
         WITH RECURSIVE thread_posts AS (
             SELECT 
@@ -227,15 +171,10 @@ func getLocalFollowingPosts(userID string) ([]Post, error) {
                 p.created_at, 
                 p.reply_to,
                 0 as depth,
-                p.created_at as thread_start,
-                p.id as root_id,
-                CAST(printf('%020d', p.id) AS TEXT) as path
+                CAST(p.created_at AS TEXT) || p.id as path
             FROM posts p
             JOIN users u ON p.user_id = u.id
-            JOIN followers f ON p.user_id = f.actor
-            WHERE p.reply_to IS NULL
-            AND f.user_id = ?
-            AND f.accepted = true
+            WHERE p.id = ?
             
             UNION ALL
             
@@ -247,9 +186,7 @@ func getLocalFollowingPosts(userID string) ([]Post, error) {
                 p.created_at, 
                 p.reply_to,
                 tp.depth + 1,
-                tp.thread_start,
-                tp.root_id,
-                tp.path || '.' || printf('%020d', p.id)
+                tp.path || '.' || CAST(p.created_at AS TEXT) || p.id
             FROM posts p
             JOIN users u ON p.user_id = u.id
             JOIN thread_posts tp ON p.reply_to = tp.id
@@ -260,186 +197,13 @@ func getLocalFollowingPosts(userID string) ([]Post, error) {
             (SELECT COUNT(*) FROM boosts WHERE post_id = thread_posts.id) as boost_count,
             (SELECT COUNT(*) FROM posts WHERE reply_to = thread_posts.id) as reply_count
         FROM thread_posts
-        ORDER BY 
-            thread_start DESC,
-            path ASC
+        ORDER BY path ASC
     `
-	return getPostsFromQuery(query, userID)
-}
-
-func getRemoteFollows(userID string) ([]Follower, error) {
-	rows, err := db.Query(`
-        SELECT id, user_id, actor, accepted, created_at
-        FROM followers
-        WHERE user_id = ?
-        AND accepted = true
-        AND actor LIKE 'http%'
-    `, userID)
+	posts, err := getPostsFromQuery(query, postID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var follows []Follower
-	for rows.Next() {
-		var f Follower
-		err := rows.Scan(&f.ID, &f.UserID, &f.Actor, &f.Accepted, &f.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		follows = append(follows, f)
-	}
-	return follows, nil
-}
-
-func fetchRemoteUserPosts(actorURI string) ([]Post, error) {
-	// First fetch the user's outbox URL
-	profile, err := FetchRemoteProfile(actorURI)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch profile: %w", err)
-	}
-
-	// Make request to outbox
-	req, err := http.NewRequest("GET", profile.OutboxURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Accept", "application/activity+json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// Parse the outbox response
-	var outbox struct {
-		First string            `json:"first"`
-		Items []json.RawMessage `json:"orderedItems"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&outbox); err != nil {
-		return nil, err
-	}
-
-	// If we got a "first" URL, fetch that page
-	if outbox.First != "" && len(outbox.Items) == 0 {
-		req, err = http.NewRequest("GET", outbox.First, nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("Accept", "application/activity+json")
-
-		resp, err = http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
-
-		if err := json.NewDecoder(resp.Body).Decode(&outbox); err != nil {
-			return nil, err
-		}
-	}
-
-	var posts []Post
-	replyMap := make(map[string]*Post) // Map to store posts by ID for reply linking
-
-	for _, item := range outbox.Items {
-		var activity struct {
-			Type   string          `json:"type"`
-			Object json.RawMessage `json:"object"`
-		}
-		if err := json.Unmarshal(item, &activity); err != nil {
-			continue
-		}
-
-		var postContent struct {
-			ID           string    `json:"id"`
-			Type         string    `json:"type"`
-			Content      string    `json:"content"`
-			Published    time.Time `json:"published"`
-			InReplyTo    *string   `json:"inReplyTo"`
-			AttributedTo string    `json:"attributedTo"`
-		}
-		if activity.Type == "Create" {
-			if err := json.Unmarshal(activity.Object, &postContent); err != nil {
-				continue
-			}
-		} else {
-			if err := json.Unmarshal(item, &postContent); err != nil {
-				continue
-			}
-		}
-
-		if postContent.Type != "Note" {
-			continue
-		}
-
-		post := Post{
-			ID:        postContent.ID,
-			Content:   postContent.Content,
-			CreatedAt: postContent.Published,
-			Author:    *profile,
-			IsLocal:   false,
-			ReplyTo:   postContent.InReplyTo,
-		}
-
-		posts = append(posts, post)
-		replyMap[post.ID] = &posts[len(posts)-1]
-	}
-
-	return posts, nil
-}
-
-func getPostsFromQuery(query string, args ...interface{}) ([]Post, error) {
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var posts []Post
-	for rows.Next() {
-		var p Post
-		var replyTo sql.NullString
-		err := rows.Scan(
-			&p.ID,
-			&p.AuthorID,
-			&p.Author.Username,
-			&p.Content,
-			&p.CreatedAt,
-			&replyTo,
-			&p.ReplyDepth,
-			&p.LikeCount,
-			&p.BoostCount,
-			&p.ReplyCount,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		if replyTo.Valid {
-			p.ReplyTo = &replyTo.String
-		}
-
-		author, err := GetProfileByID(p.AuthorID)
-		if err != nil {
-			return nil, err
-		}
-		p.Author = *author
-
-		posts = append(posts, p)
-	}
-
-	for i := range posts {
-		if posts[i].ReplyTo != nil {
-			err = posts[i].LoadParentPost()
-			if err != nil {
-				return nil, err
-			}
-		}
-	}
-
-	return posts, nil
+	return attachRemoteReplies(posts), nil
 }
 
 func GetPost(id string) (*Post, error) {
@@ -506,7 +270,13 @@ func GetPost(id string) (*Post, error) {
 	return &p, nil
 }
 
-func GetPostsByUserID(userID string) ([]Post, error) {
+// GetPostsByUserID returns a page of a user's threads, newest first.
+func GetPostsByUserID(userID string, page Page) ([]Post, PageCursors, error) {
+	roots, cursors, err := pageThreads(page, "p.user_id = ?", userID)
+	if err != nil {
+		return nil, PageCursors{}, err
+	}
+
 	query := `
         WITH RECURSIVE thread_posts AS (
     -- Get root posts (non-replies) from the user
@@ -523,8 +293,7 @@ func GetPostsByUserID(userID string) ([]Post, error) {
         CAST(printf('%020d', p.id) AS TEXT) as path
     FROM posts p
     JOIN users u ON p.user_id = u.id
-    WHERE p.reply_to IS NULL 
-    AND p.user_id = ?  -- Add this condition for user's root posts
+    WHERE p.id IN (` + placeholders(len(roots)) + `)  -- The page's root posts
     
     UNION ALL
     
@@ -550,20 +319,18 @@ SELECT
     (SELECT COUNT(*) FROM boosts WHERE post_id = thread_posts.id) as boost_count,
     (SELECT COUNT(*) FROM posts WHERE reply_to = thread_posts.id) as reply_count
 FROM thread_posts
-WHERE 
-    user_id = ? OR  -- Show user's posts
-    root_id IN (    -- And complete threads of posts they replied to
-        SELECT id FROM posts WHERE user_id = ?
-    )
 ORDER BY 
     thread_start DESC, -- Order threads by root post time
     path ASC          -- Maintain reply hierarchy within thread
-LIMIT 100
     `
 
-	rows, err := db.Query(query, userID, userID, userID)
+	args := make([]interface{}, len(roots))
+	for i, id := range roots {
+		args[i] = id
+	}
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, PageCursors{}, err
 	}
 	defer rows.Close()
 
@@ -584,7 +351,7 @@ LIMIT 100
 			&p.ReplyCount,
 		)
 		if err != nil {
-			return nil, err
+			return nil, PageCursors{}, err
 		}
 
 		if replyTo.Valid {
@@ -599,12 +366,12 @@ LIMIT 100
 		if posts[i].ReplyTo != nil {
 			err = posts[i].LoadParentPost()
 			if err != nil {
-				return nil, err
+				return nil, PageCursors{}, err
 			}
 		}
 	}
 
-	return posts, nil
+	return posts, cursors, nil
 }
 
 func CreatePost(content string, userID string) (*Post, error) {
@@ -627,13 +394,26 @@ func CreatePost(content string, userID string) (*Post, error) {
 		return nil, err
 	}
 
-	return &Post{
+	post := &Post{
 		ID:        id,
 		UserID:    userID,
 		Username:  username,
 		Content:   content,
 		CreatedAt: now,
-	}, nil
+	}
+
+	fanOut(id, userID, "", now)
+	federate(post)
+
+	return post, nil
+}
+
+// federate queues a new post for remote followers. Nothing is fetched
+// here, so the deliveries are stored before the request returns.
+func federate(post *Post) {
+	if err := FederatePost(post); err != nil {
+		log.Printf("Error federating post %s: %v", post.ID, err)
+	}
 }
 
 func CreateReply(content string, replyTo string, userID string) (*Post, error) {
@@ -666,14 +446,51 @@ func CreateReply(content string, replyTo string, userID string) (*Post, error) {
 
 	post.Username = username
 
+	fanOut(id, userID, "", now)
+	federate(post)
+
 	return post, nil
 }
 
+// DeletePost removes a local post with its likes and boosts and tells
+// remote followers it is gone.
+func DeletePost(id string) error {
+	post, err := GetPost(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM likes WHERE post_id = ?",
+		"DELETE FROM boosts WHERE post_id = ?",
+		"DELETE FROM home_timeline WHERE post_id = ?",
+		"DELETE FROM posts WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := SendDelete(post); err != nil {
+		log.Printf("Error federating deletion of post %s: %v", id, err)
+	}
+	return nil
+}
+
 func LikePost(postID, userID string) error {
 	id := uuid.New().String()
 	_, err := db.Exec(
-		"INSERT INTO likes (id, post_id, user_id) VALUES (?, ?, ?)",
-		id, postID, userID,
+		"INSERT INTO likes (id, post_id, user_id, created_at) VALUES (?, ?, ?, ?)",
+		id, postID, userID, time.Now(),
 	)
 	return err
 }
@@ -688,11 +505,20 @@ func UnlikePost(postID, userID string) error {
 
 func BoostPost(postID, userID string) error {
 	id := uuid.New().String()
+	now := time.Now()
 	_, err := db.Exec(
-		"INSERT INTO boosts (id, post_id, user_id) VALUES (?, ?, ?)",
-		id, postID, userID,
+		"INSERT INTO boosts (id, post_id, user_id, created_at) VALUES (?, ?, ?, ?)",
+		id, postID, userID, now,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	var authorID sql.NullString
+	if err := db.QueryRow("SELECT "+postAuthorID("?1"), postID).Scan(&authorID); err == nil && authorID.Valid {
+		fanOut(postID, authorID.String, userID, now)
+	}
+	return nil
 }
 
 func UnboostPost(postID, userID string) error {
@@ -700,7 +526,32 @@ func UnboostPost(postID, userID string) error {
 		"DELETE FROM boosts WHERE post_id = ? AND user_id = ?",
 		postID, userID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return removeBoostFromHomeTimelines(postID, userID)
+}
+
+// GetLikeID returns the ID of a user's like, which also identifies the
+// federated Like activity.
+func GetLikeID(postID, userID string) (string, error) {
+	var id string
+	err := db.QueryRow(
+		"SELECT id FROM likes WHERE post_id = ? AND user_id = ?",
+		postID, userID,
+	).Scan(&id)
+	return id, err
+}
+
+// GetBoostID returns the ID of a user's boost, which also identifies the
+// federated Announce activity.
+func GetBoostID(postID, userID string) (string, error) {
+	var id string
+	err := db.QueryRow(
+		"SELECT id FROM boosts WHERE post_id = ? AND user_id = ?",
+		postID, userID,
+	).Scan(&id)
+	return id, err
 }
 
 func (p *Post) LoadUserInteractions(userID string) error {
@@ -725,6 +576,10 @@ func (p *Post) LoadParentPost() error {
 	}
 
 	parent, err := GetPost(*p.ReplyTo)
+	if err == sql.ErrNoRows {
+		// The parent was deleted; show the reply on its own
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetLocalTimelineSkipsHidden(t *testing.T) {
+	users := make(map[string]string)
+	for _, name := range []string{"lt_alice", "lt_bob", "lt_carol"} {
+		user, err := CreateUser(name, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user.ID
+	}
+	alice, bob, carol := users["lt_alice"], users["lt_bob"], users["lt_carol"]
+
+	// bob and carol post in turn, and alice mutes carol
+	var bobPosts []string
+	for i := 0; i < 2; i++ {
+		for _, author := range []string{bob, carol} {
+			post, err := CreatePost("post", author)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if author == bob {
+				bobPosts = append([]string{post.ID}, bobPosts...)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := MuteActor(alice, carol, false, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	posts, _, err := GetLocalTimeline(alice, Page{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range posts {
+		got = append(got, p.ID)
+	}
+	if !reflect.DeepEqual(got, bobPosts) {
+		t.Errorf("timeline = %v, want %v", got, bobPosts)
+	}
+}
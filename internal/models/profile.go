@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"time"
@@ -20,6 +19,12 @@ type Profile struct {
 	CreatedAt   time.Time `json:"created_at"`
 	IsLocal     bool      `json:"-"`
 	OutboxURL   string    `json:"outbox,omitempty"`
+	InboxURL    string    `json:"inbox,omitempty"`
+	SharedInbox string    `json:"sharedInbox,omitempty"`
+	IconURL     string    `json:"icon,omitempty"`
+	KeyID       string    `json:"-"`
+	HideNetwork bool      `json:"-"` // hide follower and following lists
+	Locked      bool      `json:"manuallyApprovesFollowers,omitempty"`
 }
 
 func GetProfileByUsername(username string) (*Profile, error) {
@@ -37,7 +42,9 @@ func GetProfileByUsername(username string) (*Profile, error) {
             display_name,
             bio, 
             created_at,
-            public_key
+            public_key,
+            COALESCE(hide_network, FALSE),
+            locked
         FROM users 
         WHERE LOWER(username) = LOWER(?)
     `, username).Scan(
@@ -47,6 +54,8 @@ func GetProfileByUsername(username string) (*Profile, error) {
 		&bio,
 		&profile.CreatedAt,
 		&publicKey,
+		&profile.HideNetwork,
+		&profile.Locked,
 	)
 
 	if err != nil {
@@ -68,21 +77,39 @@ func GetProfileByUsername(username string) (*Profile, error) {
 	return &profile, nil
 }
 
-func GetPostsForProfile(profile *Profile) ([]Post, error) {
+// GetPostsForProfile returns a page of a profile's posts. Remote profiles
+// are paged over their stored posts; when we have none yet, the first page
+// of their outbox is fetched live instead.
+func GetPostsForProfile(profile *Profile, page Page) ([]Post, PageCursors, error) {
 	if profile.IsLocal {
 		// Fetch local posts
-		return GetPostsByUserID(profile.ID)
-	} else {
-		// Fetch or cache remote posts
-		return FetchRemotePosts(profile)
+		return GetPostsByUserID(profile.ID, page)
 	}
+
+	posts, cursors, err := GetRemotePostsByAuthor(profile.ID, page)
+	if err != nil || len(posts) > 0 || page.HasCursor() {
+		return posts, cursors, err
+	}
+
+	posts, err = FetchRemotePosts(profile)
+	return posts, PageCursors{}, err
 }
 
 func FetchRemotePosts(profile *Profile) ([]Post, error) {
 	log.Printf("Fetching posts for remote profile: %s", profile.ID)
 
+	if err := CheckFederation(profile.ID); err != nil {
+		return nil, err
+	}
+
 	// Fetch outbox
-	outboxURL := profile.ID + "/outbox"
+	outboxURL := profile.OutboxURL
+	if outboxURL == "" {
+		return nil, fmt.Errorf("actor %s has no outbox", profile.ID)
+	}
+	if err := CheckFederation(outboxURL); err != nil {
+		return nil, err
+	}
 	log.Printf("Fetching outbox from: %s", outboxURL)
 
 	req, err := http.NewRequest("GET", outboxURL, nil)
@@ -91,51 +118,37 @@ func FetchRemotePosts(profile *Profile) ([]Post, error) {
 	}
 	req.Header.Set("Accept", "application/activity+json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := fetchClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch outbox: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, &FetchError{URL: outboxURL, StatusCode: resp.StatusCode}
+	}
+
 	// Parse the initial outbox response
 	var outboxResp struct {
-		First string `json:"first"`
+		First        json.RawMessage   `json:"first"`
+		OrderedItems []json.RawMessage `json:"orderedItems"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&outboxResp); err != nil {
 		return nil, fmt.Errorf("failed to parse outbox: %w", err)
 	}
 
-	// Fetch the first page
-	log.Printf("Fetching first page: %s", outboxResp.First)
-	req, err = http.NewRequest("GET", outboxResp.First, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request for first page: %w", err)
-	}
-	req.Header.Set("Accept", "application/activity+json")
-
-	resp, err = http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch first page: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Read and log the response for debugging
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-	log.Printf("First page response: %s", string(body))
-
-	// Parse the page with a more flexible structure
-	var page struct {
-		OrderedItems []json.RawMessage `json:"orderedItems"`
-	}
-	if err := json.Unmarshal(body, &page); err != nil {
-		return nil, fmt.Errorf("failed to parse page: %w", err)
+	// Fetch the first page, which goes through the same federation checks
+	items := outboxResp.OrderedItems
+	if len(items) == 0 && len(outboxResp.First) > 0 {
+		items, err = fetchOutboxPage(outboxResp.First)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch first page: %w", err)
+		}
 	}
+	log.Printf("Fetched %d outbox items for %s", len(items), profile.ID)
 
 	var posts []Post
-	for _, itemRaw := range page.OrderedItems {
+	for _, itemRaw := range items {
 		// First try to parse as an activity
 		var activity struct {
 			Type   string          `json:"type"`
@@ -204,7 +217,9 @@ func GetProfileByID(userID string) (*Profile, error) {
             username,
             display_name,
             bio, 
-            created_at
+            created_at,
+            COALESCE(hide_network, FALSE),
+            locked
         FROM users 
         WHERE id = ?
     `, userID).Scan(
@@ -213,6 +228,8 @@ func GetProfileByID(userID string) (*Profile, error) {
 		&displayName,
 		&bio,
 		&profile.CreatedAt,
+		&profile.HideNetwork,
+		&profile.Locked,
 	)
 
 	if err != nil {
@@ -10,26 +10,101 @@ import (
 	"time"
 )
 
+// fetchClient is used for fetching remote actors and objects.
+var fetchClient = &http.Client{Timeout: 15 * time.Second}
+
+// FetchError is returned when a remote server answers a fetch with a
+// status other than 200.
+type FetchError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("fetching %s failed (status %d): %s", e.URL, e.StatusCode, e.Body)
+}
+
+// Transient reports whether the fetch may succeed if tried again later,
+// i.e. the server is struggling or rate limiting us.
+func (e *FetchError) Transient() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout
+}
+
+// FetchRemoteProfile returns a remote actor's profile, served from the
+// actors cache while it is fresh. When the remote server can't be
+// reached, a stale cached copy is returned instead of an error.
 func FetchRemoteProfile(profileURL string) (*Profile, error) {
+	if err := CheckFederation(profileURL); err != nil {
+		return nil, err
+	}
+
+	cached, fetchedAt, err := GetCachedRemoteProfile(profileURL)
+	if err == nil && time.Since(fetchedAt) < remoteActorTTL {
+		return cached, nil
+	}
+
+	profile, err := RefreshRemoteProfile(profileURL)
+	if err != nil && cached != nil {
+		log.Printf("Using stale profile for %s: %v", profileURL, err)
+		return cached, nil
+	}
+	return profile, err
+}
+
+// RefreshRemoteProfile fetches a remote actor document, bypassing the cache,
+// and stores the result.
+func RefreshRemoteProfile(profileURL string) (*Profile, error) {
 	log.Printf("Fetching remote profile from: %s", profileURL)
 
+	if err := CheckFederation(profileURL); err != nil {
+		return nil, err
+	}
+
 	req, err := http.NewRequest("GET", profileURL, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/activity+json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := fetchClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to fetch profile (status %d): %s", resp.StatusCode, string(body))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &FetchError{URL: profileURL, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := ParseActorDocument(body, profileURL)
+	if err != nil {
+		return nil, err
+	}
+	// Only the actor's own URL may speak for it, or any server could
+	// overwrite another actor's key and inbox
+	if profile.ID != profileURL {
+		return nil, fmt.Errorf("actor document at %s claims id %s", profileURL, profile.ID)
 	}
 
+	if err := StoreRemoteProfile(profile); err != nil {
+		log.Printf("Failed to cache profile %s: %v", profile.ID, err)
+	}
+
+	log.Printf("Fetched profile for @%s@%s", profile.Username, profile.Domain)
+	return profile, nil
+}
+
+// ParseActorDocument converts an ActivityPub actor document into a Profile.
+// The domain is taken from profileURL, or from the actor ID when empty.
+func ParseActorDocument(data []byte, profileURL string) (*Profile, error) {
 	var actorData struct {
 		ID                string `json:"id"`
 		Type              string `json:"type"`
@@ -37,21 +112,30 @@ func FetchRemoteProfile(profileURL string) (*Profile, error) {
 		Name              string `json:"name"`
 		Summary           string `json:"summary"`
 		Outbox            string `json:"outbox"`
-		PublicKey         struct {
+		Inbox             string `json:"inbox"`
+		Endpoints         struct {
+			SharedInbox string `json:"sharedInbox"`
+		} `json:"endpoints"`
+		Icon      json.RawMessage `json:"icon"`
+		PublicKey struct {
+			ID           string `json:"id"`
 			PublicKeyPem string `json:"publicKeyPem"`
 		} `json:"publicKey"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&actorData); err != nil {
+	if err := json.Unmarshal(data, &actorData); err != nil {
 		return nil, err
 	}
 
+	if profileURL == "" {
+		profileURL = actorData.ID
+	}
 	parsedURL, err := url.Parse(profileURL)
 	if err != nil {
 		return nil, err
 	}
 
-	profile := &Profile{
+	return &Profile{
 		ID:          actorData.ID,
 		Username:    actorData.PreferredUsername,
 		Domain:      parsedURL.Host,
@@ -59,25 +143,67 @@ func FetchRemoteProfile(profileURL string) (*Profile, error) {
 		Bio:         actorData.Summary,
 		PublicKey:   actorData.PublicKey.PublicKeyPem,
 		OutboxURL:   actorData.Outbox,
+		InboxURL:    actorData.Inbox,
+		SharedInbox: actorData.Endpoints.SharedInbox,
+		IconURL:     parseIconURL(actorData.Icon),
+		KeyID:       actorData.PublicKey.ID,
 		IsLocal:     false,
 		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// parseIconURL accepts an icon given as a URL, an Image object or a list
+// of either, and returns the first URL found.
+func parseIconURL(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
 	}
 
-	log.Printf("Fetched profile for @%s@%s", profile.Username, profile.Domain)
-	return profile, nil
+	var url string
+	if err := json.Unmarshal(raw, &url); err == nil {
+		return url
+	}
+
+	var image struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(raw, &image); err == nil && image.URL != "" {
+		return image.URL
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		for _, item := range list {
+			if url := parseIconURL(item); url != "" {
+				return url
+			}
+		}
+	}
+	return ""
 }
+
 func WebFingerLookup(username, domain string) (string, error) {
-	webfingerURL := fmt.Sprintf("https://%s/.well-known/webfinger?resource=acct:%s@%s",
-		domain, username, domain)
+	if err := CheckFederation(domain); err != nil {
+		return "", err
+	}
+
+	webfingerURL := fmt.Sprintf("https://%s/.well-known/webfinger?resource=%s",
+		domain, url.QueryEscape("acct:"+username+"@"+domain))
+
+	req, err := http.NewRequest("GET", webfingerURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/jrd+json, application/json")
 
-	resp, err := http.Get(webfingerURL)
+	resp, err := fetchClient.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("webfinger lookup failed: %d", resp.StatusCode)
+		return "", &FetchError{URL: webfingerURL, StatusCode: resp.StatusCode}
 	}
 
 	var wf struct {
@@ -94,6 +220,9 @@ func WebFingerLookup(username, domain string) (string, error) {
 
 	for _, link := range wf.Links {
 		if link.Rel == "self" && link.Type == "application/activity+json" {
+			if err := CheckFederation(link.Href); err != nil {
+				return "", err
+			}
 			return link.Href, nil
 		}
 	}
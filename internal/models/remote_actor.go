@@ -0,0 +1,179 @@
+package models
+
+import (
+	"Aervyn/internal/activitypub"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Cached actor documents are refetched after this long
+const remoteActorTTL = 24 * time.Hour
+
+// GetCachedRemoteProfile returns the cached profile of a remote actor and
+// when it was fetched.
+func GetCachedRemoteProfile(actor string) (*Profile, time.Time, error) {
+	var profile Profile
+	var fetchedAt time.Time
+	var username, domain, displayName, bio, publicKey, inbox, sharedInbox, outbox, icon, keyID sql.NullString
+
+	err := db.QueryRow(`
+        SELECT uri, username, domain, display_name, bio, public_key,
+               inbox, shared_inbox, outbox, icon, key_id, fetched_at
+        FROM actors
+        WHERE uri = ? AND NOT local AND fetched_at IS NOT NULL
+    `, actor).Scan(
+		&profile.ID,
+		&username,
+		&domain,
+		&displayName,
+		&bio,
+		&publicKey,
+		&inbox,
+		&sharedInbox,
+		&outbox,
+		&icon,
+		&keyID,
+		&fetchedAt,
+	)
+	if err != nil {
+		return nil, fetchedAt, err
+	}
+
+	profile.Username = username.String
+	profile.Domain = domain.String
+	profile.DisplayName = displayName.String
+	profile.Bio = bio.String
+	profile.PublicKey = publicKey.String
+	profile.InboxURL = inbox.String
+	profile.SharedInbox = sharedInbox.String
+	profile.OutboxURL = outbox.String
+	profile.IconURL = icon.String
+	profile.KeyID = keyID.String
+	profile.IsLocal = false
+
+	return &profile, fetchedAt, nil
+}
+
+// StoreRemoteProfile caches the latest known state of a remote actor.
+func StoreRemoteProfile(profile *Profile) error {
+	if profile.IconURL != "" && rejectsMedia(profile.ID) {
+		profile.IconURL = ""
+	}
+
+	_, err := db.Exec(`
+        INSERT INTO actors
+        (id, uri, local, username, domain, display_name, bio, public_key, inbox, shared_inbox, outbox, icon, key_id, fetched_at)
+        VALUES (?, ?, FALSE, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(uri) DO UPDATE SET
+        username = excluded.username,
+        domain = excluded.domain,
+        display_name = excluded.display_name,
+        bio = excluded.bio,
+        public_key = excluded.public_key,
+        inbox = excluded.inbox,
+        shared_inbox = excluded.shared_inbox,
+        outbox = excluded.outbox,
+        icon = excluded.icon,
+        key_id = excluded.key_id,
+        fetched_at = excluded.fetched_at
+    `,
+		uuid.New().String(),
+		profile.ID,
+		profile.Username,
+		profile.Domain,
+		profile.DisplayName,
+		profile.Bio,
+		profile.PublicKey,
+		profile.InboxURL,
+		profile.SharedInbox,
+		profile.OutboxURL,
+		profile.IconURL,
+		profile.KeyID,
+		time.Now(),
+	)
+	return err
+}
+
+// resolveActorKey backs signature verification with the cached remote
+// actors. A cached key is only used when the actor named by the keyId
+// published it. With refresh set, the owning actor is refetched so a
+// rotated key is picked up.
+func resolveActorKey(keyID string, refresh bool) (string, string, error) {
+	actor, _, _ := strings.Cut(keyID, "#")
+	if err := CheckFederation(keyID); err != nil {
+		return "", "", err
+	}
+
+	if !refresh {
+		var publicKey, owner string
+		var fetchedAt time.Time
+		err := db.QueryRow(`
+            SELECT public_key, uri, fetched_at
+            FROM actors
+            WHERE uri = ?1 AND (key_id = ?2 OR uri = ?2)
+            AND public_key != '' AND NOT local
+        `, actor, keyID).Scan(&publicKey, &owner, &fetchedAt)
+		if err == nil && time.Since(fetchedAt) < remoteActorTTL {
+			return publicKey, owner, nil
+		}
+	}
+
+	profile, err := RefreshRemoteProfile(actor)
+	if err == nil && profile.ID == actor && profile.PublicKey != "" && (profile.KeyID == keyID || profile.ID == keyID) {
+		return profile.PublicKey, profile.ID, nil
+	}
+
+	// The key lives in its own document rather than on the actor.
+	// FetchActorKeyPem checks that the owner's document publishes it.
+	publicKey, owner, fetchErr := activitypub.FetchActorKeyPem(keyID)
+	if fetchErr != nil {
+		if err != nil {
+			return "", "", fmt.Errorf("%v; %w", err, fetchErr)
+		}
+		return "", "", fetchErr
+	}
+	return publicKey, owner, nil
+}
+
+// RefreshRemoteActor force-refreshes a cached actor, for the admin command.
+func RefreshRemoteActor(actor string) error {
+	profile, err := RefreshRemoteProfile(actor)
+	if err != nil {
+		return err
+	}
+	log.Printf("Refreshed @%s@%s (key %s)", profile.Username, profile.Domain, profile.KeyID)
+	return nil
+}
+
+// PurgeRemoteActor removes everything we know about a deleted remote actor:
+// follows in both directions, likes, boosts, cached posts and profile.
+func PurgeRemoteActor(actor string) error {
+	id := findActorID(actor)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DELETE FROM followers WHERE user_id = ?2 OR actor = ?2",
+		"DELETE FROM likes WHERE user_id = ?2 OR post_id IN (SELECT id FROM remote_posts WHERE author = ?2)",
+		"DELETE FROM boosts WHERE user_id = ?2 OR post_id IN (SELECT id FROM remote_posts WHERE author = ?2)",
+		"DELETE FROM remote_posts WHERE author = ?2",
+		"DELETE FROM outbox_polls WHERE actor_id = ?2",
+		"DELETE FROM home_timeline WHERE author_id = ?2 OR boosted_by = ?2",
+		"DELETE FROM actors WHERE uri = ?1 AND NOT local AND id NOT IN " + moderatedActors,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, actor, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,473 @@
+package models
+
+import (
+	"Aervyn/internal/activitypub"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// RemoteNote is the subset of an inbound Note we keep in remote_posts.
+type RemoteNote struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	AttributedTo string    `json:"attributedTo"`
+	Content      string    `json:"content"`
+	InReplyTo    *string   `json:"inReplyTo"`
+	Published    time.Time `json:"published"`
+	URL          string    `json:"url"`
+	To           []string  `json:"to"`
+	Cc           []string  `json:"cc"`
+}
+
+// UnmarshalJSON accepts the other valid forms of a Note's references: to
+// and cc given as a single string, and inReplyTo as an embedded object.
+func (n *RemoteNote) UnmarshalJSON(data []byte) error {
+	type plain RemoteNote
+	var raw struct {
+		plain
+		InReplyTo json.RawMessage `json:"inReplyTo"`
+		To        interface{}     `json:"to"`
+		Cc        interface{}     `json:"cc"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*n = RemoteNote(raw.plain)
+	n.To = addressList(raw.To)
+	n.Cc = addressList(raw.Cc)
+	n.InReplyTo = nil
+	if len(raw.InReplyTo) > 0 && string(raw.InReplyTo) != "null" {
+		obj, err := ParseObject(raw.InReplyTo)
+		if err != nil {
+			return fmt.Errorf("invalid inReplyTo: %w", err)
+		}
+		if obj.ID != "" {
+			n.InReplyTo = &obj.ID
+		}
+	}
+	return nil
+}
+
+// Who may see a remote note, from its addressing: everyone (public), everyone
+// but off public timelines (unlisted), the author's followers, or only the
+// actors it names (direct).
+const (
+	visibilityPublic    = "public"
+	visibilityUnlisted  = "unlisted"
+	visibilityFollowers = "followers"
+	visibilityDirect    = "direct"
+)
+
+// noteVisibility works out a note's visibility from its to and cc.
+func noteVisibility(to, cc []string, author string) string {
+	switch {
+	case activitypub.IsPublic(to, nil):
+		return visibilityPublic
+	case activitypub.IsPublic(nil, cc):
+		return visibilityUnlisted
+	case toFollowers(to, author) || toFollowers(cc, author):
+		return visibilityFollowers
+	default:
+		return visibilityDirect
+	}
+}
+
+var remotePostColumns = `id, ` + actorRef("remote_posts.author") + `, content, in_reply_to, published, url`
+
+// StoreRemoteNote inserts or refreshes a remote Note keyed by its object URI.
+// Only the note's author may refresh it, and deleted notes stay deleted.
+func StoreRemoteNote(note *RemoteNote) error {
+	to, err := json.Marshal(note.To)
+	if err != nil {
+		return err
+	}
+	cc, err := json.Marshal(note.Cc)
+	if err != nil {
+		return err
+	}
+
+	published := note.Published
+	if published.IsZero() {
+		published = time.Now()
+	}
+
+	authorID, err := actorID(note.AttributedTo)
+	if err != nil {
+		return err
+	}
+	visibility := noteVisibility(note.To, note.Cc, note.AttributedTo)
+
+	result, err := db.Exec(`
+        INSERT INTO remote_posts
+        (id, author, content, in_reply_to, published, url, to_addresses, cc_addresses, visibility, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(id) DO UPDATE SET
+        content = excluded.content,
+        updated_at = excluded.created_at,
+        in_reply_to = excluded.in_reply_to,
+        url = excluded.url,
+        to_addresses = excluded.to_addresses,
+        cc_addresses = excluded.cc_addresses,
+        visibility = excluded.visibility
+        WHERE remote_posts.author = excluded.author
+        AND remote_posts.deleted_at IS NULL
+    `, note.ID, authorID, note.Content, note.InReplyTo, published, note.URL, string(to), string(cc), visibility, time.Now())
+	if err != nil {
+		return err
+	}
+	// Another author's note, or one that was deleted, is left alone
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	if err := addNoteToHomeTimelines(note, authorID, visibility, published); err != nil {
+		log.Printf("Error adding %s to home timelines: %v", note.ID, err)
+	}
+	return nil
+}
+
+// migrateVisibility works out the visibility of notes stored before it was
+// recorded.
+func migrateVisibility() error {
+	rows, err := db.Query(`
+        SELECT id, ` + actorRef("author") + `, COALESCE(to_addresses, 'null'), COALESCE(cc_addresses, 'null')
+        FROM remote_posts
+        WHERE visibility IS NULL
+    `)
+	if err != nil {
+		return err
+	}
+	visibilities := make(map[string]string)
+	for rows.Next() {
+		var id, author, to, cc string
+		if err := rows.Scan(&id, &author, &to, &cc); err != nil {
+			rows.Close()
+			return err
+		}
+		var toList, ccList []string
+		json.Unmarshal([]byte(to), &toList)
+		json.Unmarshal([]byte(cc), &ccList)
+		visibilities[id] = noteVisibility(toList, ccList, author)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, visibility := range visibilities {
+		if _, err := db.Exec("UPDATE remote_posts SET visibility = ? WHERE id = ?", visibility, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRemoteReplies returns stored public and unlisted remote replies to a
+// post, oldest first.
+func GetRemoteReplies(objectID string) ([]Post, error) {
+	return getRemotePosts(`
+        SELECT `+remotePostColumns+`
+        FROM remote_posts
+        WHERE in_reply_to = ?
+        AND visibility IN ('public', 'unlisted')
+        AND deleted_at IS NULL
+        ORDER BY published ASC
+    `, objectID)
+}
+
+// GetRemotePostsByAuthor returns a page of a remote actor's stored public
+// and unlisted posts, newest first.
+func GetRemotePostsByAuthor(actor string, page Page) ([]Post, PageCursors, error) {
+	limit := page.limit(timelinePageSize)
+	pageWhere, pageArgs := page.where("(published, id)", "SELECT published, id FROM remote_posts WHERE id = ?")
+
+	args := append([]interface{}{findActorID(actor)}, pageArgs...)
+	posts, err := getRemotePosts(`
+        SELECT `+remotePostColumns+`
+        FROM remote_posts
+        WHERE author = ?
+        AND visibility IN ('public', 'unlisted')
+        AND deleted_at IS NULL
+        AND `+pageWhere+`
+        ORDER BY `+page.order("published", "id")+`
+        LIMIT ?
+    `, append(args, limit)...)
+	if err != nil {
+		return nil, PageCursors{}, err
+	}
+
+	ids := make([]string, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	if page.MinID != "" {
+		for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+			posts[i], posts[j] = posts[j], posts[i]
+		}
+	}
+	return posts, page.cursors(ids, limit), nil
+}
+
+func getRemotePosts(query string, args ...interface{}) ([]Post, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	authors := make(map[string]*Profile)
+	for rows.Next() {
+		var p Post
+		var inReplyTo, postURL sql.NullString
+		err := rows.Scan(&p.ID, &p.AuthorID, &p.Content, &inReplyTo, &p.CreatedAt, &postURL)
+		if err != nil {
+			return nil, err
+		}
+
+		if inReplyTo.Valid && inReplyTo.String != "" {
+			replyTo := inReplyTo.String
+			if id, ok := localPostID(replyTo); ok {
+				replyTo = id
+			}
+			p.ReplyTo = &replyTo
+		}
+
+		p.URL = p.ID
+		if postURL.Valid && postURL.String != "" {
+			p.URL = postURL.String
+		}
+
+		if _, ok := authors[p.AuthorID]; !ok {
+			authors[p.AuthorID] = remoteAuthor(p.AuthorID)
+		}
+		p.Author = *authors[p.AuthorID]
+		p.IsLocal = false
+
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// remoteAuthor fetches the author profile of a stored post, falling back to
+// a name derived from the actor URI when the remote server is unreachable.
+func remoteAuthor(actor string) *Profile {
+	profile, err := FetchRemoteProfile(actor)
+	if err == nil {
+		return profile
+	}
+	log.Printf("Failed to fetch author %s: %v", actor, err)
+
+	profile = &Profile{ID: actor}
+	if u, err := url.Parse(actor); err == nil {
+		profile.Domain = u.Host
+		profile.Username = path.Base(u.Path)
+	}
+	return profile
+}
+
+// attachRemoteReplies inserts stored remote replies after the posts they
+// answer, one level deeper, and counts them in the parent's ReplyCount.
+func attachRemoteReplies(posts []Post) []Post {
+	result := make([]Post, 0, len(posts))
+	for _, p := range posts {
+		objectID := p.ID
+		if !strings.HasPrefix(objectID, "http") {
+			objectID = activitypub.NoteID(p.ID)
+		}
+
+		replies, err := remoteReplyTree(objectID, p.ReplyDepth+1, 0)
+		if err != nil {
+			log.Printf("Error loading remote replies to %s: %v", p.ID, err)
+		}
+
+		p.ReplyCount += countDirectReplies(replies, p.ReplyDepth+1)
+		result = append(result, p)
+		result = append(result, replies...)
+	}
+	return result
+}
+
+func remoteReplyTree(objectID string, depth, level int) ([]Post, error) {
+	if level >= 10 {
+		return nil, nil
+	}
+
+	replies, err := GetRemoteReplies(objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree []Post
+	for _, reply := range replies {
+		reply.ReplyDepth = depth
+		children, err := remoteReplyTree(reply.ID, depth+1, level+1)
+		if err != nil {
+			return nil, err
+		}
+		reply.ReplyCount = countDirectReplies(children, depth+1)
+		tree = append(tree, reply)
+		tree = append(tree, children...)
+	}
+	return tree, nil
+}
+
+func countDirectReplies(posts []Post, depth int) int {
+	count := 0
+	for _, p := range posts {
+		if p.ReplyDepth == depth {
+			count++
+		}
+	}
+	return count
+}
+
+// processCreate stores a Note pushed to our inbox. Only the Note's author
+// may create it.
+func (a *Activity) processCreate() error {
+	var raw struct {
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal([]byte(a.RawData), &raw); err != nil {
+		return err
+	}
+
+	var note RemoteNote
+	if err := json.Unmarshal(raw.Object, &note); err != nil {
+		return fmt.Errorf("create object is not embedded: %w", err)
+	}
+
+	if note.Type != "Note" {
+		log.Printf("Ignoring Create of %s", note.Type)
+		return nil
+	}
+	if note.ID == "" {
+		return fmt.Errorf("create of note without id")
+	}
+	if note.AttributedTo != a.Actor {
+		return fmt.Errorf("create by %s of note attributed to %s", a.Actor, note.AttributedTo)
+	}
+	if hostOf(note.ID) != hostOf(a.Actor) {
+		return fmt.Errorf("create by %s of note %s on another host", a.Actor, note.ID)
+	}
+
+	if blockedReply(&note) {
+		log.Printf("Ignoring reply from %s, who is blocked by the author", a.Actor)
+		return nil
+	}
+
+	return StoreRemoteNote(&note)
+}
+
+// blockedReply reports whether note replies to a local post whose author
+// blocked the note's author. Such replies are dropped.
+func blockedReply(note *RemoteNote) bool {
+	if note.InReplyTo == nil {
+		return false
+	}
+	postID, ok := localPostID(*note.InReplyTo)
+	if !ok {
+		return false
+	}
+	parent, err := GetPost(postID)
+	return err == nil && IsBlocked(parent.UserID, note.AttributedTo)
+}
+
+// processDelete tombstones a remote Note, or purges an actor that deleted
+// itself. Actors may only delete their own objects.
+func (a *Activity) processDelete() error {
+	obj, err := a.object()
+	if err != nil {
+		return err
+	}
+
+	if obj.ID == a.Actor {
+		log.Printf("Purging deleted actor %s", a.Actor)
+		return PurgeRemoteActor(a.Actor)
+	}
+
+	var author string
+	err = db.QueryRow("SELECT "+actorRef("author")+" FROM remote_posts WHERE id = ?", obj.ID).Scan(&author)
+	if err == sql.ErrNoRows {
+		log.Printf("Ignoring Delete of unknown object %s", obj.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if author != a.Actor {
+		return fmt.Errorf("delete by %s of object owned by %s", a.Actor, author)
+	}
+
+	now := time.Now()
+	_, err = db.Exec(`
+        UPDATE remote_posts
+        SET content = '', deleted_at = ?, updated_at = ?
+        WHERE id = ?
+    `, now, now, obj.ID)
+	return err
+}
+
+// processUpdate refreshes a cached Note or actor profile. Notes must belong
+// to the updating actor, and actors may only update themselves.
+func (a *Activity) processUpdate() error {
+	var raw struct {
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal([]byte(a.RawData), &raw); err != nil {
+		return err
+	}
+
+	obj, err := ParseObject(raw.Object)
+	if err != nil {
+		return err
+	}
+
+	switch obj.Type {
+	case "Note":
+		var note RemoteNote
+		if err := json.Unmarshal(raw.Object, &note); err != nil {
+			return err
+		}
+		if note.AttributedTo != a.Actor {
+			return fmt.Errorf("update by %s of note attributed to %s", a.Actor, note.AttributedTo)
+		}
+
+		var author string
+		err := db.QueryRow(
+			"SELECT "+actorRef("author")+" FROM remote_posts WHERE id = ? AND deleted_at IS NULL", note.ID,
+		).Scan(&author)
+		if err == sql.ErrNoRows {
+			log.Printf("Ignoring Update of unknown note %s", note.ID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if author != a.Actor {
+			return fmt.Errorf("update by %s of note owned by %s", a.Actor, author)
+		}
+
+		return StoreRemoteNote(&note)
+	case "Person", "Service", "Application", "Group", "Organization":
+		if obj.ID != a.Actor {
+			return fmt.Errorf("update by %s of actor %s", a.Actor, obj.ID)
+		}
+
+		profile, err := ParseActorDocument(raw.Object, "")
+		if err != nil {
+			return err
+		}
+		return StoreRemoteProfile(profile)
+	default:
+		log.Printf("Ignoring Update of %s", obj.Type)
+		return nil
+	}
+}
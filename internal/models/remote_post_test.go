@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRemoteNoteUnmarshal(t *testing.T) {
+	const public = "https://www.w3.org/ns/activitystreams#Public"
+	const followers = "https://remote.example/users/alice/followers"
+	const parent = "https://local.example/posts/1"
+
+	tests := []struct {
+		name          string
+		json          string
+		wantTo        []string
+		wantCc        []string
+		wantInReplyTo string
+	}{
+		{
+			name:          "lists and reply by ID",
+			json:          `{"to": ["` + public + `"], "cc": ["` + followers + `"], "inReplyTo": "` + parent + `"}`,
+			wantTo:        []string{public},
+			wantCc:        []string{followers},
+			wantInReplyTo: parent,
+		},
+		{
+			name:          "single strings and embedded reply",
+			json:          `{"to": "` + public + `", "cc": "` + followers + `", "inReplyTo": {"type": "Note", "id": "` + parent + `"}}`,
+			wantTo:        []string{public},
+			wantCc:        []string{followers},
+			wantInReplyTo: parent,
+		},
+		{
+			name: "no addressing and null reply",
+			json: `{"inReplyTo": null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var note RemoteNote
+			if err := json.Unmarshal([]byte(`{"id": "https://remote.example/notes/1", "type": "Note", "content": "hi", `+tt.json[1:]), &note); err != nil {
+				t.Fatalf("Unmarshal() error: %v", err)
+			}
+			if note.ID != "https://remote.example/notes/1" || note.Type != "Note" || note.Content != "hi" {
+				t.Errorf("Unmarshal() lost plain fields: %+v", note)
+			}
+			if !reflect.DeepEqual(note.To, tt.wantTo) {
+				t.Errorf("To = %v, want %v", note.To, tt.wantTo)
+			}
+			if !reflect.DeepEqual(note.Cc, tt.wantCc) {
+				t.Errorf("Cc = %v, want %v", note.Cc, tt.wantCc)
+			}
+
+			var inReplyTo string
+			if note.InReplyTo != nil {
+				inReplyTo = *note.InReplyTo
+			}
+			if inReplyTo != tt.wantInReplyTo {
+				t.Errorf("InReplyTo = %q, want %q", inReplyTo, tt.wantInReplyTo)
+			}
+		})
+	}
+}
+
+func TestNoteVisibility(t *testing.T) {
+	const public = "https://www.w3.org/ns/activitystreams#Public"
+	const author = "https://remote.example/users/alice"
+	const followers = author + "/followers"
+	const bob = "https://local.example/users/bob"
+
+	tests := []struct {
+		name string
+		to   []string
+		cc   []string
+		want string
+	}{
+		{"public in to", []string{public}, []string{followers}, visibilityPublic},
+		{"public in cc", []string{followers}, []string{public}, visibilityUnlisted},
+		{"compact public in cc", []string{followers}, []string{"as:Public"}, visibilityUnlisted},
+		{"followers only", []string{followers}, []string{bob}, visibilityFollowers},
+		{"followers in cc", []string{bob}, []string{followers}, visibilityFollowers},
+		{"someone else's followers", []string{"https://other.example/users/carol/followers"}, nil, visibilityDirect},
+		{"mentions only", []string{bob}, nil, visibilityDirect},
+		{"no addressing", nil, nil, visibilityDirect},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := noteVisibility(tt.to, tt.cc, author); got != tt.want {
+				t.Errorf("noteVisibility(%v, %v) = %q, want %q", tt.to, tt.cc, got, tt.want)
+			}
+		})
+	}
+}
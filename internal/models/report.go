@@ -0,0 +1,419 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+
+	// Moderator actions on a report
+	ReportDismiss = "dismiss"
+	ReportWarn    = "warn"
+	ReportDelete  = "delete"
+	ReportSilence = "silence"
+	ReportSuspend = "suspend"
+)
+
+type Report struct {
+	ID         string
+	Reporter   string
+	Target     string
+	Comment    string
+	Forward    bool
+	RemoteID   string
+	Status     string
+	Action     string
+	ResolvedBy string
+	ResolvedAt *time.Time
+	CreatedAt  time.Time
+
+	Posts []ReportedPost
+}
+
+// ReportedPost is a post attached to a report with whatever content we
+// still have for it.
+type ReportedPost struct {
+	ID      string
+	Content string
+}
+
+// CreateReport files a report against target covering the given posts.
+func CreateReport(report *Report, postIDs []string) error {
+	if report.ID == "" {
+		report.ID = uuid.New().String()
+	}
+	report.Status = ReportOpen
+	report.CreatedAt = time.Now()
+
+	reporterID, err := actorID(report.Reporter)
+	if err != nil {
+		return err
+	}
+	targetID, err := actorID(report.Target)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var remoteID interface{}
+	if report.RemoteID != "" {
+		remoteID = report.RemoteID
+	}
+	_, err = tx.Exec(`
+        INSERT INTO reports (id, reporter, target, comment, forward, remote_id, status, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, report.ID, reporterID, targetID, report.Comment, report.Forward,
+		remoteID, report.Status, report.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for _, postID := range postIDs {
+		_, err := tx.Exec(`
+            INSERT INTO report_posts (report_id, post_id)
+            VALUES (?, ?)
+            ON CONFLICT DO NOTHING
+        `, report.ID, postID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// reportColumns are the columns scanReport reads, with reporter and target
+// turned back into actor refs.
+var reportColumns = `id, ` + actorRef("reports.reporter") + `, ` + actorRef("reports.target") + `,
+        COALESCE(comment, ''), forward, COALESCE(remote_id, ''), status, COALESCE(action, ''),
+        COALESCE(resolved_by, ''), resolved_at, created_at`
+
+// maxReportsPerHour caps how many reports a user may file in an hour.
+const maxReportsPerHour = 10
+
+// ReportLimitReached reports whether the user has filed as many reports in
+// the last hour as they may.
+func ReportLimitReached(reporter string) (bool, error) {
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM reports WHERE reporter = ? AND created_at > ?",
+		reporter, time.Now().Add(-time.Hour),
+	).Scan(&count)
+	return count >= maxReportsPerHour, err
+}
+
+// GetReports returns reports with the given status, newest first.
+func GetReports(status string) ([]Report, error) {
+	rows, err := db.Query(`
+        SELECT `+reportColumns+`
+        FROM reports
+        WHERE status = ?
+        ORDER BY created_at DESC
+    `, status)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []Report
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reports = append(reports, *r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range reports {
+		if reports[i].Posts, err = getReportedPosts(reports[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return reports, nil
+}
+
+func GetReport(id string) (*Report, error) {
+	r, err := scanReport(db.QueryRow(`
+        SELECT `+reportColumns+`
+        FROM reports
+        WHERE id = ?
+    `, id))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Posts, err = getReportedPosts(r.ID)
+	return r, err
+}
+
+func scanReport(row interface{ Scan(...interface{}) error }) (*Report, error) {
+	var r Report
+	var resolvedAt sql.NullTime
+	err := row.Scan(&r.ID, &r.Reporter, &r.Target, &r.Comment, &r.Forward, &r.RemoteID,
+		&r.Status, &r.Action, &r.ResolvedBy, &resolvedAt, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if resolvedAt.Valid {
+		r.ResolvedAt = &resolvedAt.Time
+	}
+	return &r, nil
+}
+
+func getReportedPosts(reportID string) ([]ReportedPost, error) {
+	rows, err := db.Query(`
+        SELECT rp.post_id, COALESCE(p.content, rem.content, '')
+        FROM report_posts rp
+        LEFT JOIN posts p ON p.id = rp.post_id
+        LEFT JOIN remote_posts rem ON rem.id = rp.post_id
+        WHERE rp.report_id = ?
+    `, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []ReportedPost
+	for rows.Next() {
+		var p ReportedPost
+		if err := rows.Scan(&p.ID, &p.Content); err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// ResolveReport applies a moderator's action to a report's target and
+// closes the report. reason is shown to the target for warnings.
+func ResolveReport(id, action, moderatorID, reason string) error {
+	report, err := GetReport(id)
+	if err != nil {
+		return err
+	}
+	if report.Status != ReportOpen {
+		return fmt.Errorf("report %s is already resolved", id)
+	}
+
+	switch action {
+	case ReportDismiss:
+	case ReportWarn:
+		err = warnAccount(report, reason)
+	case ReportDelete:
+		err = deleteReportedPosts(report)
+	case ReportSilence, ReportSuspend:
+		err = moderateActor(report.Target, action, report.ID)
+	default:
+		return fmt.Errorf("unknown report action %q", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+        UPDATE reports
+        SET status = ?, action = ?, resolved_by = ?, resolved_at = ?
+        WHERE id = ?
+    `, ReportResolved, action, moderatorID, time.Now(), id)
+	return err
+}
+
+func warnAccount(report *Report, reason string) error {
+	if strings.HasPrefix(report.Target, "http") {
+		return fmt.Errorf("only local accounts can be warned")
+	}
+	if reason == "" {
+		reason = "A moderator reviewed a report about your account."
+	}
+
+	_, err := db.Exec(`
+        INSERT INTO account_warnings (id, user_id, report_id, text, created_at)
+        VALUES (?, ?, ?, ?, ?)
+    `, uuid.New().String(), report.Target, report.ID, reason, time.Now())
+	return err
+}
+
+// GetAccountWarnings returns the warnings moderators sent a user.
+func GetAccountWarnings(userID string) ([]string, error) {
+	rows, err := db.Query(`
+        SELECT text FROM account_warnings
+        WHERE user_id = ?
+        ORDER BY created_at DESC
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var warnings []string
+	for rows.Next() {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, text)
+	}
+	return warnings, rows.Err()
+}
+
+// deleteReportedPosts removes the reported posts: local posts are deleted
+// and federated as such, cached remote posts are tombstoned. Posts by
+// anyone but the reported account are left alone.
+func deleteReportedPosts(report *Report) error {
+	for _, p := range report.Posts {
+		if !strings.HasPrefix(p.ID, "http") {
+			post, err := GetPost(p.ID)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if post.UserID != report.Target {
+				continue
+			}
+			if err := DeletePost(p.ID); err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			continue
+		}
+
+		now := time.Now()
+		_, err := db.Exec(`
+            UPDATE remote_posts
+            SET content = '', deleted_at = ?, updated_at = ?
+            WHERE id = ? AND author = ?
+        `, now, now, p.ID, findActorID(report.Target))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moderateActor silences or suspends a single account. Suspended remote
+// actors are purged like a deleted actor.
+func moderateActor(actor, severity, reportID string) error {
+	id, err := actorID(actor)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+        INSERT INTO actor_moderation (actor, severity, report_id, created_at)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT(actor) DO UPDATE SET
+        severity = excluded.severity,
+        report_id = excluded.report_id
+    `, id, severity, reportID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if severity == ReportSuspend && strings.HasPrefix(actor, "http") {
+		return PurgeRemoteActor(actor)
+	}
+	return nil
+}
+
+// actorModeration returns "silence", "suspend" or "" for an account.
+func actorModeration(actor string) string {
+	var severity string
+	db.QueryRow("SELECT severity FROM actor_moderation WHERE actor = ?", findActorID(actor)).Scan(&severity)
+	return severity
+}
+
+// IsSuspended reports whether moderators suspended a local or remote account.
+func IsSuspended(actor string) bool {
+	return actorModeration(actor) == ReportSuspend
+}
+
+// ReportTarget works out who a post belongs to, as a local user ID or a
+// remote actor URI. Only local posts and remote posts already stored here
+// can be reported, so a report never makes us fetch anything.
+func ReportTarget(postID string) (string, error) {
+	if !strings.HasPrefix(postID, "http") {
+		post, err := GetPost(postID)
+		if err != nil {
+			return "", err
+		}
+		return post.UserID, nil
+	}
+
+	if author := storedPostAuthor(postID); author != "" {
+		return author, nil
+	}
+	return "", sql.ErrNoRows
+}
+
+// processFlag files a report sent by another server about local users.
+// The Flag's object lists the reported accounts and posts.
+func (a *Activity) processFlag() error {
+	if block, err := GetDomainBlock(a.Actor); err == nil && block.RejectReports {
+		log.Printf("Ignoring Flag from %s, whose reports are rejected", a.Actor)
+		return nil
+	}
+
+	var raw struct {
+		Content string          `json:"content"`
+		Object  json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal([]byte(a.RawData), &raw); err != nil {
+		return err
+	}
+
+	var objects []json.RawMessage
+	if err := json.Unmarshal(raw.Object, &objects); err != nil {
+		objects = []json.RawMessage{raw.Object}
+	}
+
+	var target string
+	var postIDs []string
+	for _, item := range objects {
+		obj, err := ParseObject(item)
+		if err != nil {
+			continue
+		}
+		if userID, ok := localUserID(obj.ID); ok {
+			target = userID
+		} else if postID, ok := localPostID(obj.ID); ok {
+			postIDs = append(postIDs, postID)
+		}
+	}
+
+	if target == "" && len(postIDs) > 0 {
+		post, err := GetPost(postIDs[0])
+		if err == nil {
+			target = post.UserID
+		}
+	}
+	if target == "" {
+		log.Printf("Ignoring Flag %s with no local target", a.ID)
+		return nil
+	}
+
+	return CreateReport(&Report{
+		Reporter: a.Actor,
+		Target:   target,
+		Comment:  raw.Content,
+		RemoteID: a.ID,
+	}, postIDs)
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"Aervyn/internal/activitypub"
+	"time"
+)
+
+// GetNodeInfoUsage counts local users and posts for NodeInfo. A user is
+// active if they posted, liked or boosted within the period.
+func GetNodeInfoUsage() (activitypub.NodeInfoUsage, error) {
+	var usage activitypub.NodeInfoUsage
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&usage.Users.Total); err != nil {
+		return usage, err
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&usage.LocalPosts); err != nil {
+		return usage, err
+	}
+
+	var err error
+	now := time.Now()
+	usage.Users.ActiveMonth, err = countActiveUsers(now.AddDate(0, 0, -30))
+	if err != nil {
+		return usage, err
+	}
+	usage.Users.ActiveHalfyear, err = countActiveUsers(now.AddDate(0, 0, -180))
+	return usage, err
+}
+
+func countActiveUsers(since time.Time) (int, error) {
+	var count int
+	err := db.QueryRow(`
+        SELECT COUNT(DISTINCT user_id) FROM (
+            SELECT user_id FROM posts WHERE created_at >= ?1
+            UNION
+            SELECT user_id FROM likes WHERE created_at >= ?1
+            UNION
+            SELECT user_id FROM boosts WHERE created_at >= ?1
+        )
+        WHERE user_id IN (SELECT id FROM users)
+    `, since).Scan(&count)
+	return count, err
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,27 +21,39 @@ type User struct {
 	Password    string
 	PublicKey   string
 	PrivateKey  string
+	IsAdmin     bool
+	Locked      bool // follows need approval
 	CreatedAt   time.Time
 }
 
-func CreateUser(username, password string) (*User, error) {
+// generateKeyPair returns a new PEM encoded RSA key pair for signing.
+func generateKeyPair() (publicKeyPEM, privateKeyPEM string, err error) {
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
-	publicKeyPEM := &bytes.Buffer{}
-	pem.Encode(publicKeyPEM, &pem.Block{
+	publicBuf := &bytes.Buffer{}
+	pem.Encode(publicBuf, &pem.Block{
 		Type:  "RSA PUBLIC KEY",
 		Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey),
 	})
 
-	privateKeyPEM := &bytes.Buffer{}
-	pem.Encode(privateKeyPEM, &pem.Block{
+	privateBuf := &bytes.Buffer{}
+	pem.Encode(privateBuf, &pem.Block{
 		Type:  "RSA PRIVATE KEY",
 		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
 	})
 
+	return publicBuf.String(), privateBuf.String(), nil
+}
+
+func CreateUser(username, password string) (*User, error) {
+	publicKeyPEM, privateKeyPEM, err := generateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -50,30 +63,33 @@ func CreateUser(username, password string) (*User, error) {
 	id := uuid.New().String()
 	_, err = db.Exec(
 		"INSERT INTO users (id, username, password, public_key, private_key) VALUES (?, ?, ?, ?, ?)",
-		id, username, string(hashedPassword), publicKeyPEM.String(), privateKeyPEM.String(),
+		id, username, string(hashedPassword), publicKeyPEM, privateKeyPEM,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if err := addLocalActor(id, username); err != nil {
+		return nil, err
+	}
 
 	return &User{ID: id, Username: username}, nil
 }
 
-func (u *User) UpdateProfile(displayName, bio string) error {
+func (u *User) UpdateProfile(displayName, bio string, hideNetwork, locked bool) error {
 	_, err := db.Exec(`
         UPDATE users 
-        SET display_name = ?, bio = ?
+        SET display_name = ?, bio = ?, hide_network = ?, locked = ?
         WHERE id = ?
-    `, displayName, bio, u.ID)
+    `, displayName, bio, hideNetwork, locked, u.ID)
 	return err
 }
 
 func GetUserByUsername(username string) (*User, error) {
 	var user User
 	err := db.QueryRow(
-		"SELECT id, username, password FROM users WHERE LOWER(username) = LOWER(?)",
+		"SELECT id, username, password, COALESCE(public_key, ''), COALESCE(private_key, '') FROM users WHERE LOWER(username) = LOWER(?)",
 		username,
-	).Scan(&user.ID, &user.Username, &user.Password)
+	).Scan(&user.ID, &user.Username, &user.Password, &user.PublicKey, &user.PrivateKey)
 	if err != nil {
 		return nil, err
 	}
@@ -83,15 +99,27 @@ func GetUserByUsername(username string) (*User, error) {
 func GetUserByID(id string) (*User, error) {
 	var user User
 	err := db.QueryRow(
-		"SELECT id, username, created_at FROM users WHERE id = ?",
+		"SELECT id, username, created_at, COALESCE(public_key, ''), COALESCE(private_key, ''), is_admin, locked FROM users WHERE id = ?",
 		id,
-	).Scan(&user.ID, &user.Username, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.CreatedAt, &user.PublicKey, &user.PrivateKey, &user.IsAdmin, &user.Locked)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// SetAdmin grants or revokes access to the admin pages.
+func SetAdmin(username string, admin bool) error {
+	result, err := db.Exec("UPDATE users SET is_admin = ? WHERE LOWER(username) = LOWER(?)", admin, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no user named %s", username)
+	}
+	return nil
+}
+
 func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 	return err == nil